@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nemith/heartsick/homesick"
+	"github.com/spf13/cobra"
+)
+
+// bootstrapReport is the outcome of bootstrapping a single castle from a
+// manifest.
+type bootstrapReport struct {
+	entry      homesick.ManifestCastle
+	cloned     bool
+	pulled     int
+	checkedOut bool
+	linked     int
+	rc         string
+	err        error
+}
+
+func (r *bootstrapReport) String() string {
+	if r.err != nil {
+		return r.err.Error()
+	}
+
+	var parts []string
+	if r.cloned {
+		parts = append(parts, "cloned")
+	} else {
+		parts = append(parts, fmt.Sprintf("pulled %d file(s)", r.pulled))
+	}
+	if r.checkedOut {
+		parts = append(parts, "checked out "+r.entry.Ref)
+	}
+	if r.entry.Link {
+		parts = append(parts, fmt.Sprintf("linked %d file(s)", r.linked))
+	}
+	if r.rc != "" {
+		parts = append(parts, r.rc)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// bootstrapCastle will bring a single castle in line with its manifest entry:
+// clone or pull it (and its dependencies), check out its ref, link it and run
+// its .homesickrc if it changed since it was last run.  Every step is skipped
+// if there is nothing to do so bootstrapping again is safe.
+func bootstrapCastle(m homesick.ManifestCastle, state *homesick.State) *bootstrapReport {
+	r := &bootstrapReport{entry: m}
+	git := home.Git()
+	before := castleNames()
+
+	c, err := home.Castle(m.Name)
+	switch {
+	case err == homesick.ErrCastleNotExist:
+		c, err = home.Clone(m.URI, m.Name)
+		if err != nil {
+			r.err = err
+			return r
+		}
+		r.cloned = true
+	case err != nil:
+		r.err = fmt.Errorf("failed to load castle: %v", err)
+		return r
+	default:
+		if remote, err := c.Remote(); err == nil && remote != m.URI {
+			statusf(colorBrRed, "mismatch", "castle '%s' is cloned from %s not %s", m.Name, remote, m.URI)
+		}
+	}
+
+	pull := !r.cloned
+	if m.Ref != "" {
+		if !r.cloned {
+			statusf(colorBrGreen, "git fetch", "castle '%s'", m.Name)
+			if err := git.Fetch(c.Path); err != nil {
+				r.err = fmt.Errorf("failed to fetch: %v", err)
+				return r
+			}
+		}
+
+		want, err := git.ResolveRef(c.Path, m.Ref)
+		if err != nil {
+			r.err = fmt.Errorf("failed to find '%s': %v", m.Ref, err)
+			return r
+		}
+		if head, _ := git.Head(c.Path); head != want {
+			statusf(colorBrGreen, "git checkout", "%s in castle '%s'", m.Ref, m.Name)
+			if err := git.Checkout(c.Path, m.Ref); err != nil {
+				r.err = fmt.Errorf("failed to check out '%s': %v", m.Ref, err)
+				return r
+			}
+			r.checkedOut = true
+		}
+
+		// tags and commits leave nothing to pull from
+		if upstream, _ := git.Upstream(c.Path); upstream == "" {
+			pull = false
+		}
+	}
+
+	if pull {
+		pulled, err := c.Pull()
+		if err != nil {
+			r.err = err
+			return r
+		}
+		r.pulled = len(pulled)
+	}
+
+	castles, err := home.DependencyOrder([]*homesick.Castle{c}, true)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	if err := postCloneHooks(before); err != nil {
+		r.err = err
+		return r
+	}
+
+	if m.Link {
+		for _, dep := range castles {
+			linked, err := dep.Link(state, conflictPrompt)
+			if err != nil {
+				r.err = err
+				return r
+			}
+			if dep.Name == c.Name {
+				r.linked = len(linked)
+			}
+		}
+	}
+
+	if m.RC {
+		sum, err := c.RCChecksum()
+		if err != nil {
+			r.err = err
+			return r
+		}
+		switch {
+		case sum == "":
+			r.rc = "no rc"
+		case state.RC[c.Name] == sum:
+			r.rc = "rc unchanged"
+		default:
+			if _, err := c.RunRC(); err != nil {
+				r.err = err
+				return r
+			}
+			state.RecordRC(c.Name, sum)
+			if err := state.Save(); err != nil {
+				r.err = fmt.Errorf("failed to save state: %v", err)
+				return r
+			}
+			r.rc = "ran rc"
+		}
+	}
+
+	return r
+}
+
+func cmdBootstrap(cmd *cobra.Command, args []string) {
+	manifest, err := homesick.LoadManifest(args[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	state, err := home.LoadState()
+	if err != nil {
+		fatalf("failed to load state: %v", err)
+	}
+
+	reports := make([]*bootstrapReport, 0, len(manifest))
+	for _, m := range manifest {
+		statusf(colorBrCyan, "bootstrap", "castle '%s'", m.Name)
+		reports = append(reports, bootstrapCastle(m, state))
+	}
+
+	fmt.Println()
+	var fail bool
+	for _, r := range reports {
+		if r.err != nil {
+			status(colorBrRed, r.entry.Name, r.String())
+			fail = true
+			continue
+		}
+		status(colorBrGreen, r.entry.Name, r.String())
+	}
+	if fail {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nemith/heartsick/homesick"
+)
+
+func TestBootstrapCastle(t *testing.T) {
+	fake := useFakeGit(t)
+
+	out := filepath.Join(home.Dir(), "rc.out")
+	fake.remotes["https://example.com/dotfiles.git"] = &fakeRemote{
+		files: map[string]string{
+			"home/.vimrc": "",
+			".homesickrc": "#!/bin/sh\necho x >> " + out + "\n",
+		},
+		refs: map[string]string{"v2": "2"},
+	}
+
+	m := homesick.ManifestCastle{
+		Name: "dotfiles",
+		URI:  "https://example.com/dotfiles.git",
+		Ref:  "v2",
+		Link: true,
+		RC:   true,
+	}
+
+	state, _ := home.LoadState()
+	r := bootstrapCastle(m, state)
+	if r.err != nil {
+		t.Fatalf("unexpected error: %v", r.err)
+	}
+	if !r.cloned || !r.checkedOut || r.linked != 1 || r.rc != "ran rc" {
+		t.Errorf("wrong report: %s", r)
+	}
+	if _, err := os.Readlink(filepath.Join(home.Dir(), ".vimrc")); err != nil {
+		t.Errorf("castle wasn't linked: %v", err)
+	}
+
+	// a second run has nothing left to do
+	r = bootstrapCastle(m, state)
+	if r.err != nil {
+		t.Fatalf("unexpected error: %v", r.err)
+	}
+	if r.cloned || r.checkedOut || r.linked != 0 || r.rc != "rc unchanged" {
+		t.Errorf("wrong report: %s", r)
+	}
+	if got, _ := ioutil.ReadFile(out); string(got) != "x\n" {
+		t.Errorf("rc should only run once, got: %q", got)
+	}
+
+	// and the state survives a reload
+	state, _ = home.LoadState()
+	if r = bootstrapCastle(m, state); r.rc != "rc unchanged" {
+		t.Errorf("wrong report: %s", r)
+	}
+}
+
+func TestBootstrapCastleHooks(t *testing.T) {
+	fake := useFakeGit(t)
+
+	out := filepath.Join(home.Dir(), "hooks.out")
+	hook := "#!/bin/sh\necho \"$HEARTSICK_CASTLE $HEARTSICK_HOOK\" >> " + out + "\n"
+	hooks := func(files map[string]string) map[string]string {
+		for _, h := range []homesick.Hook{homesick.HookPostClone, homesick.HookPreLink, homesick.HookPostLink} {
+			files["hooks/"+string(h)] = hook
+		}
+		return files
+	}
+	for _, name := range []string{"untrusted", "trusted"} {
+		fake.remotes["https://example.com/"+name+".git"] = &fakeRemote{
+			files: hooks(map[string]string{
+				".homesick_deps": "[" + name + "-dep]\nuri = https://example.com/" + name + "-dep.git\n",
+				"home/." + name:  "",
+			}),
+		}
+		fake.remotes["https://example.com/"+name+"-dep.git"] = &fakeRemote{
+			files: hooks(map[string]string{"home/." + name + "-dep": ""}),
+		}
+	}
+
+	// nothing from a fresh clone or its dependencies runs without --run-hooks
+	state, _ := home.LoadState()
+	m := homesick.ManifestCastle{Name: "untrusted", URI: "https://example.com/untrusted.git", Link: true}
+	if r := bootstrapCastle(m, state); r.err != nil || r.linked != 1 {
+		t.Fatalf("wrong report: %s", r)
+	}
+	if got, err := ioutil.ReadFile(out); err == nil {
+		t.Errorf("hooks ran without --run-hooks: %q", got)
+	}
+
+	flagRunHooks = true
+	defer func() { flagRunHooks = false }()
+	m = homesick.ManifestCastle{Name: "trusted", URI: "https://example.com/trusted.git", Link: true}
+	if r := bootstrapCastle(m, state); r.err != nil {
+		t.Fatalf("unexpected error: %v", r.err)
+	}
+	got, _ := ioutil.ReadFile(out)
+	want := "trusted post-clone\ntrusted-dep post-clone\n" +
+		"trusted-dep pre-link\ntrusted-dep post-link\n" +
+		"trusted pre-link\ntrusted post-link\n"
+	if string(got) != want {
+		t.Errorf("wrong hooks run (got: %q, want: %q)", got, want)
+	}
+}
@@ -1,183 +1,460 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"time"
 
+	"github.com/nemith/heartsick/homesick"
 	"github.com/spf13/cobra"
 )
 
 var (
-	flagAll bool
+	flagAll  bool
+	flagDeep bool
+
+	flagCommitUntracked bool
+	flagCommitEdit      bool
+
+	flagPushNoFetch bool
+
+	flagCheckQuiet bool
+
+	flagInitCheck bool
+
+	flagExecParallel bool
+
+	flagRunHooks    bool
+	flagTrustRevoke bool
+
+	flagGenerateTemplate string
+	flagGenerateTrack    bool
+
+	flagWatchDebounce time.Duration
+	flagWatchPush     bool
+	flagWatchInstall  bool
 )
 
 func init() {
+	adoptCmd := &cobra.Command{
+		Use:               "adopt CASTLE [PATH...]",
+		Short:             "move files from the home directory into a castle and link them",
+		Run:               cmdAdopt,
+		ValidArgsFunction: completeArgs(argCastle, argFile),
+		Args:              cobra.MinimumNArgs(1),
+	}
+
+	checkCmd := &cobra.Command{
+		Use:               "check [CASTLE|@GROUP...]",
+		Short:             "check castles for modified links and drifted permissions",
+		Run:               cmdCheck,
+		ValidArgsFunction: completeArgs(argCastles),
+	}
+	checkCmd.Flags().BoolVarP(&flagCheckQuiet, "quiet", "q", false, "only report problems")
+
 	cloneCmd := &cobra.Command{
 		Use:   "clone URI CASTLE_NAME",
 		Short: "clone +uri+ as a castle with name CASTLE_NAME for homesick",
 		Run:   cmdClone,
 		Args:  cobra.RangeArgs(1, 2),
 	}
+	cloneCmd.Flags().BoolVarP(&flagRunHooks, "run-hooks", "", false, "trust and run the hooks of the cloned castles")
 
 	commitCmd := &cobra.Command{
-		Use:   "commit CASTLE MESSAGE",
-		Short: "commit the specified castle's changes",
-		Run:   cmdCommit,
+		Use:               "commit CASTLE MESSAGE",
+		Short:             "commit the specified castle's changes",
+		Run:               cmdCommit,
+		ValidArgsFunction: completeArgs(argCastles, argNone),
 	}
+	commitCmd.Flags().BoolVarP(&flagAll, "all", "", false, "commit all cloned castles with MESSAGE")
+	commitCmd.Flags().BoolVarP(&flagCommitUntracked, "untracked", "u", false, "also commit untracked files under home/")
+	commitCmd.Flags().BoolVarP(&flagCommitEdit, "edit", "e", false, "edit the commit message in your editor")
 
 	// TODO(bbennett): cmdDestory
 
+	completionCmd := &cobra.Command{
+		Use:       "completion bash|zsh|fish",
+		Short:     "print the shell completion script",
+		Run:       cmdCompletion,
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"bash", "zsh", "fish"},
+	}
+
 	diffCmd := &cobra.Command{
-		Use:   "diff CASTLE",
-		Short: "shows the git diff of uncommitted changes in a castle",
-		Run:   cmdDiff,
+		Use:               "diff [CASTLE|@GROUP...]",
+		Short:             "shows the git diff of uncommitted changes in a castle",
+		Run:               cmdDiff,
+		ValidArgsFunction: completeArgs(argCastles),
 	}
 
 	execCmd := &cobra.Command{
-		Use:   "exec CASTLE|all COMMAND",
-		Short: "execute a single shell command inside the root of a castle",
-		Run:   cmdExec,
-		Args:  cobra.MinimumNArgs(2),
+		Use:               "exec CASTLE|@GROUP|all COMMAND [ARG...]",
+		Short:             "execute a single shell command inside the root of a castle",
+		Run:               cmdExec,
+		ValidArgsFunction: completeArgs(argCastles, argNone),
+		Args:              cobra.MinimumNArgs(2),
 	}
+	execCmd.Flags().BoolVarP(&flagExecParallel, "parallel", "p", false, "run in every castle at once prefixing output with the castle name")
+	execCmd.Flags().SetInterspersed(false)
 
 	execAllCmd := &cobra.Command{
-		Use:   "exec_all COMMAND",
+		Use:   "exec_all COMMAND [ARG...]",
 		Short: "execute a single shell command inside the root of every cloned castle",
 		Run:   cmdExecAll,
 		Args:  cobra.MinimumNArgs(1),
 	}
+	execAllCmd.Flags().BoolVarP(&flagExecParallel, "parallel", "p", false, "run in every castle at once prefixing output with the castle name")
+	execAllCmd.Flags().SetInterspersed(false)
+
+	exportCmd := &cobra.Command{
+		Use:               "export OUT [CASTLE|@GROUP...]",
+		Short:             "pack castles into git bundles for an offline import",
+		Run:               cmdExport,
+		ValidArgsFunction: completeArgs(argFile, argCastles),
+		Args:              cobra.MinimumNArgs(1),
+	}
+	exportCmd.Flags().BoolVarP(&flagAll, "all", "", false, "export all cloned castles")
+
+	importCmd := &cobra.Command{
+		Use:               "import IN",
+		Short:             "clone or fast-forward castles from an export",
+		Run:               cmdImport,
+		ValidArgsFunction: completeArgs(argFile, argNone),
+		Args:              cobra.ExactArgs(1),
+	}
+	importCmd.Flags().BoolVarP(&flagRunHooks, "run-hooks", "", false, "trust and run the hooks of the imported castles")
 
 	generateCmd := &cobra.Command{
-		Use:   "generate PATH",
-		Short: "generate a homesick-ready git repo at PATH",
-		Run:   cmdGenerate,
-		Args:  cobra.ExactArgs(1),
+		Use:               "generate PATH [--track FILE...]",
+		Short:             "generate a homesick-ready git repo at PATH",
+		Run:               cmdGenerate,
+		ValidArgsFunction: completeArgs(argFile),
+		Args:              cobra.MinimumNArgs(1),
+	}
+	generateCmd.Flags().StringVarP(&flagGenerateTemplate, "template", "t", homesick.DefaultTemplate, "template to scaffold the castle from (builtin or a directory in ~/.homesick/templates)")
+	generateCmd.Flags().BoolVarP(&flagGenerateTrack, "track", "", false, "move the given files into the new castle and link them back")
+
+	initCmd := &cobra.Command{
+		Use:       "init bash|zsh|fish",
+		Short:     "print shell integration that lets `cd` change the current directory",
+		Run:       cmdInit,
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"bash", "zsh", "fish"},
 	}
+	initCmd.Flags().BoolVarP(&flagInitCheck, "check", "", false, "run `check` when a login shell starts")
 
 	linkCmd := &cobra.Command{
-		Use:     "link",
-		Aliases: []string{"symlink"},
-		Short:   "symlinks all dotfiles from the specified castle",
-		Run:     cmdLink,
+		Use:               "link [CASTLE|@GROUP...]",
+		Aliases:           []string{"symlink"},
+		Short:             "symlinks all dotfiles from the specified castle",
+		Run:               cmdLink,
+		ValidArgsFunction: completeArgs(argCastles),
 	}
+	linkCmd.Flags().BoolVarP(&flagDeep, "deep", "", false, "link individual files instead of directories, dependencies included")
+	linkCmd.Flags().StringVarP(&flagKeyFile, "key-file", "", "", "key file used to decrypt secrets")
 
 	listCmd := &cobra.Command{
-		Use:   "list",
-		Short: "list cloned castles",
-		Run:   cmdList,
-		Args:  cobra.NoArgs,
+		Use:               "list",
+		Short:             "list cloned castles",
+		Run:               cmdList,
+		ValidArgsFunction: cobra.NoFileCompletions,
+		Args:              cobra.NoArgs,
 	}
 
 	openCmd := &cobra.Command{
-		Use:     "open CASTLE",
-		Aliases: []string{"edit"},
-		Short:   "open your default editor in the root of the given castle",
-		Run:     cmdOpen,
+		Use:               "open CASTLE [FILE]",
+		Aliases:           []string{"edit"},
+		Short:             "open your editor in the root of the given castle or on one of its files",
+		Run:               cmdOpen,
+		ValidArgsFunction: completeArgs(argCastle, argCastleFile, argNone),
+		Args:              cobra.MaximumNArgs(2),
 	}
 
 	pathCmd := &cobra.Command{
-		Use:     "show_path CASTLE",
-		Aliases: []string{"path"},
-		Short:   "prints the path of a castle",
-		Run:     cmdPath,
+		Use:               "show_path CASTLE",
+		Aliases:           []string{"path"},
+		Short:             "prints the path of a castle",
+		Run:               cmdPath,
+		ValidArgsFunction: completeArgs(argCastle, argNone),
 	}
 
 	pullCmd := &cobra.Command{
-		Use:   "pull CASTLE",
-		Short: "update the specified castle",
-		Run:   cmdPull,
+		Use:               "pull [CASTLE|@GROUP...]",
+		Short:             "update the specified castle",
+		Run:               cmdPull,
+		ValidArgsFunction: completeArgs(argCastles),
 	}
 	pullCmd.PersistentFlags().BoolVarP(&flagAll, "all", "", false, "update all cloned castles")
 
 	pushCmd := &cobra.Command{
-		Use:   "push CASTLE",
-		Short: "push the specified castle",
-		Run:   cmdPush,
+		Use:               "push [CASTLE|@GROUP...]",
+		Short:             "push the specified castle",
+		Run:               cmdPush,
+		ValidArgsFunction: completeArgs(argCastles),
 	}
+	pushCmd.Flags().BoolVarP(&flagAll, "all", "", false, "push all cloned castles that have unpushed commits")
+	pushCmd.Flags().BoolVarP(&flagPushNoFetch, "no-fetch", "", false, "don't fetch before checking for unpushed commits")
 
 	rcCmd := &cobra.Command{
-		Use:   "rc CASTLE",
-		Short: "un the .homesickrc for the specified castle",
-		Run:   cmdRC,
+		Use:               "rc CASTLE",
+		Short:             "run the .homesickrc for the specified castle",
+		Run:               cmdRC,
+		ValidArgsFunction: completeArgs(argCastle, argNone),
 	}
 
+	secretCmd := &cobra.Command{
+		Use:   "secret",
+		Short: "manage encrypted secret files in a castle",
+	}
+	secretCmd.PersistentFlags().StringVarP(&flagKeyFile, "key-file", "", "", "key file used to encrypt secrets")
+
+	secretAddCmd := &cobra.Command{
+		Use:               "add FILE CASTLE",
+		Short:             "encrypt FILE from the home directory into a castle",
+		Run:               cmdSecretAdd,
+		ValidArgsFunction: completeArgs(argFile, argCastle, argNone),
+		Args:              cobra.RangeArgs(1, 2),
+	}
+	secretCmd.AddCommand(secretAddCmd)
+
 	shellCmd := &cobra.Command{
-		Use:     "cd CASTLE",
-		Aliases: []string{"shell"},
-		Short:   "open a new shell in the root of the given castle",
-		Run:     cmdShell,
+		Use:               "cd CASTLE",
+		Aliases:           []string{"shell"},
+		Short:             "open a new shell in the root of the given castle",
+		Run:               cmdShell,
+		ValidArgsFunction: completeArgs(argCastle, argNone),
 	}
 
 	statusCmd := &cobra.Command{
-		Use:   "status CASTLE",
-		Short: "shows the git status of a castle",
-		Run:   cmdStatus,
+		Use:               "status [CASTLE|@GROUP...]",
+		Short:             "shows the git status of a castle",
+		Run:               cmdStatus,
+		ValidArgsFunction: completeArgs(argCastles),
 	}
 
 	trackCmd := &cobra.Command{
-		Use:   "track FILE CASTLE",
-		Short: "add a file to a castle",
-		Run:   cmdTrack,
-		Args:  cobra.MinimumNArgs(1),
+		Use:               "track FILE CASTLE",
+		Short:             "add a file to a castle",
+		Run:               cmdTrack,
+		ValidArgsFunction: completeArgs(argFile, argCastle, argNone),
+		Args:              cobra.MinimumNArgs(1),
 	}
 
-	// TODO(bbennett): unlinkCmd
+	trustCmd := &cobra.Command{
+		Use:               "trust CASTLE|@GROUP...",
+		Short:             "allow the hooks of a castle to run",
+		Run:               cmdTrust,
+		ValidArgsFunction: completeArgs(argCastles),
+		Args:              cobra.MinimumNArgs(1),
+	}
+	trustCmd.Flags().BoolVarP(&flagTrustRevoke, "revoke", "", false, "stop the hooks of the castle from running")
+
+	unlinkCmd := &cobra.Command{
+		Use:               "unlink [CASTLE|@GROUP...]",
+		Aliases:           []string{"unsymlink"},
+		Short:             "unsymlinks all dotfiles linked from the specified castle",
+		Run:               cmdUnlink,
+		ValidArgsFunction: completeArgs(argCastles),
+	}
+
+	pruneCmd := &cobra.Command{
+		Use:               "prune",
+		Short:             "remove links to files that no longer exist in their castle",
+		Run:               cmdPrune,
+		ValidArgsFunction: cobra.NoFileCompletions,
+		Args:              cobra.NoArgs,
+	}
 
 	versionCmd := &cobra.Command{
-		Use:     "version",
-		Aliases: []string{"ver"},
-		Short:   "display the current version of homesick",
-		Run:     cmdVersion,
-		Args:    cobra.NoArgs,
+		Use:               "version",
+		Aliases:           []string{"ver"},
+		Short:             "display the current version of homesick",
+		Run:               cmdVersion,
+		ValidArgsFunction: cobra.NoFileCompletions,
+		Args:              cobra.NoArgs,
 	}
 
+	bootstrapCmd := &cobra.Command{
+		Use:               "bootstrap FILE",
+		Short:             "clone, link and run the rc of every castle in a manifest",
+		Run:               cmdBootstrap,
+		ValidArgsFunction: completeArgs(argFile, argNone),
+		Args:              cobra.ExactArgs(1),
+	}
+	bootstrapCmd.Flags().BoolVarP(&flagRunHooks, "run-hooks", "", false, "trust and run the hooks of the cloned castles")
+
+	syncCmd := &cobra.Command{
+		Use:               "sync [CASTLE|@GROUP...]",
+		Short:             "pull, link, commit and push castles in one go",
+		Run:               cmdSync,
+		ValidArgsFunction: completeArgs(argCastles),
+	}
+	syncCmd.Flags().BoolVarP(&flagAll, "all", "", false, "sync all cloned castles")
+
+	watchCmd := &cobra.Command{
+		Use:               "watch [CASTLE|@GROUP...]",
+		Short:             "watch castles for changes and commit them automatically",
+		Run:               cmdWatch,
+		ValidArgsFunction: completeArgs(argCastles),
+	}
+	watchCmd.Flags().BoolVarP(&flagAll, "all", "", false, "watch all cloned castles")
+	watchCmd.Flags().DurationVarP(&flagWatchDebounce, "debounce", "", 30*time.Second, "how long changes must settle before committing")
+	watchCmd.Flags().BoolVarP(&flagWatchPush, "push", "", false, "push after every commit")
+	watchCmd.Flags().BoolVarP(&flagWatchInstall, "install", "", false, "install the watcher as a user service instead of running it")
+
 	rootCmd.AddCommand(
+		adoptCmd,
+		bootstrapCmd,
+		checkCmd,
 		cloneCmd,
 		commitCmd,
+		completionCmd,
 		diffCmd,
 		execAllCmd,
 		execCmd,
+		exportCmd,
 		generateCmd,
+		importCmd,
+		initCmd,
 		linkCmd,
 		listCmd,
 		openCmd,
 		pathCmd,
+		pruneCmd,
 		pullCmd,
 		pushCmd,
 		rcCmd,
+		secretCmd,
 		shellCmd,
 		statusCmd,
+		syncCmd,
 		trackCmd,
+		trustCmd,
+		unlinkCmd,
 		versionCmd,
+		watchCmd,
 	)
 }
 
-func castleFromArgs(args []string) *castle {
-	var name = defaultCastle
-	if len(args) > 0 {
-		name = args[0]
+// castleFromArgs will load the castle named by the first argument or the
+// default castle.  Groups are only allowed if they contain a single castle.
+func castleFromArgs(args []string) *homesick.Castle {
+	if len(args) > 1 {
+		args = args[:1]
+	}
+	castles := castlesFromArgs(args)
+	if len(castles) != 1 {
+		fatalf("group '%s' has %d castles but only one castle can be used here", args[0], len(castles))
+	}
+	return castles[0]
+}
+
+// castlesFromArgs will load every castle named in args expanding any
+// `@group` from the config.  The default castle is used if args is empty.
+func castlesFromArgs(args []string) []*homesick.Castle {
+	if len(args) == 0 {
+		args = []string{homesick.DefaultCastle}
+	}
+
+	names, err := mustConfig().Resolve(args)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	castles := make([]*homesick.Castle, 0, len(names))
+	for _, name := range names {
+		castle, err := home.Castle(name)
+		if err != nil {
+			fatalf("failed to load castle '%s': %s", name, err)
+		}
+		castles = append(castles, castle)
 	}
-	castle, err := loadCastle(name)
+	return castles
+}
+
+// profileCastles is like castlesFromArgs but uses the profile for this host
+// from the config when no castles are given.  The default castle is only used
+// when there is no profile; a profile without castles leaves nothing to do.
+func profileCastles(args []string) []*homesick.Castle {
+	if len(args) == 0 {
+		hostname, _ := os.Hostname()
+		names, ok, err := mustConfig().Profile(hostname)
+		if err != nil {
+			fatalf("failed to load profile: %v", err)
+		}
+		if ok && len(names) == 0 {
+			statusf(colorBrBlue, "profile", "no castles for host '%s', nothing to do", hostname)
+			return nil
+		}
+		args = names
+	}
+	return castlesFromArgs(args)
+}
+
+func mustConfig() *homesick.Config {
+	cfg, err := home.LoadConfig()
 	if err != nil {
-		fatalf("failed to load castle: %s", err)
+		fatalf("%v", err)
 	}
-	return castle
+	return cfg
 }
 
-func mustAllCastles() []*castle {
-	castles, err := allCastles()
+func mustAllCastles() []*homesick.Castle {
+	castles, err := home.Castles()
 	if err != nil {
 		fatalf("failed to find castles: %s", err)
 	}
 	return castles
 }
 
-var githubPattern = regexp.MustCompile(`^([A-Za-z0-9_-]+/[A-Za-z0-9_-]+)$`)
+func cmdCheck(cmd *cobra.Command, args []string) {
+	var castles []*homesick.Castle
+	if len(args) == 0 {
+		castles = mustAllCastles()
+	} else {
+		castles = castlesFromArgs(args)
+	}
+
+	state, err := home.LoadState()
+	if err != nil {
+		fatalf("failed to load state: %v", err)
+	}
+
+	var problems int
+	for _, c := range castles {
+		drift, err := c.CheckModes()
+		if err != nil {
+			errorf("failed to check permissions for castle '%s': %v", c.Name, err)
+			problems++
+			continue
+		}
+		for _, d := range drift {
+			statusf(colorBrRed, "mode", "%s is %#o should be %#o", d.Path, d.Got, d.Want)
+		}
+
+		var modified int
+		for _, e := range state.CastleEntries(c.Name) {
+			if !e.Intact() {
+				statusf(colorBrRed, "modified", "%s was changed since it was linked", e.Target)
+				modified++
+			}
+		}
+
+		if len(drift) == 0 && modified == 0 && !flagCheckQuiet {
+			statusf(colorBrGreen, "ok", "castle '%s'", c.Name)
+		}
+		problems += len(drift) + modified
+	}
+
+	if problems > 0 {
+		os.Exit(1)
+	}
+}
 
 func cmdClone(cmd *cobra.Command, args []string) {
 	uri := args[0]
@@ -188,248 +465,348 @@ func cmdClone(cmd *cobra.Command, args []string) {
 	}
 
 	// expand out a github path if only user/repo given
-	if githubPattern.MatchString(uri) {
-		uri = "https://github.com/" + uri + ".git"
-	}
+	uri = homesick.ExpandURI(uri)
 
 	// obtain the castle name from the repo name if one wasn't pas
 	if castleName == "" {
-		castleName = strings.TrimSuffix(filepath.Base(uri), ".git")
+		castleName = homesick.CastleName(uri)
 	}
 
-	dest := filepath.Join(castlePath(), castleName)
+	dest := home.CastlePath(castleName)
+	before := castleNames()
 
 	if _, err := os.Stat(dest); err == nil {
 		status(colorBrBlue, "exist", dest)
-		return
+	} else if _, err := home.Clone(uri, castleName); err != nil {
+		fatalf("%v", err)
 	}
 
-	statusf(colorBrGreen, "git clone", "%s to %s", uri, dest)
-	if err := gitClone(uri, dest); err != nil {
-		fatalf("failed to clone '%s': %v", uri, err)
+	// dependencies are cloned even if the castle already existed so a
+	// failed clone can be finished by running clone again.
+	castle, err := home.Castle(castleName)
+	if err != nil {
+		fatalf("failed to load castle: %v", err)
 	}
-	// TODO(bbennett): clone/update submodules?
-}
-
-func cmdCommit(cmd *cobra.Command, args []string) {
-	castle := castleFromArgs(args)
-
-	var commitMsg string
-	if len(args) > 1 {
-		commitMsg = strings.Join(args[1:], " ")
-
+	if _, err := home.DependencyOrder([]*homesick.Castle{castle}, true); err != nil {
+		fatalf("%v", err)
 	}
-
-	status(colorBrGreen, "git commit all", castle.name)
-	if err := gitCommitAll(castle.path, commitMsg); err != nil {
-		fatalf("failed to commit: %v", err)
+	if err := postCloneHooks(before); err != nil {
+		fatalf("%v", err)
 	}
 }
 
-func cmdDiff(cmd *cobra.Command, args []string) {
-	castle := castleFromArgs(args)
-
-	status(colorBrGreen, "git diff", castle.name)
-	if err := gitDiff(castle.path); err != nil {
-		fatalf("failed to diff: %v", err)
+// castleNames returns the names of the castles that are cloned.
+func castleNames() map[string]bool {
+	castles, err := home.Castles()
+	if err != nil {
+		fatalf("failed to find castles: %v", err)
 	}
+	names := make(map[string]bool, len(castles))
+	for _, c := range castles {
+		names[c.Name] = true
+	}
+	return names
 }
 
-func execHelper(castle *castle, args []string) {
-	var cmdArgs []string
-	if len(args) > 1 {
-		cmdArgs = args[1:]
+// postCloneHooks will run the post-clone hook of every castle that wasn't in
+// before.  Hooks come straight from the remote so the new castles are only
+// trusted to run them if --run-hooks was given, otherwise RunHook points the
+// hook out.
+func postCloneHooks(before map[string]bool) error {
+	castles, err := home.Castles()
+	if err != nil {
+		return fmt.Errorf("failed to find castles: %v", err)
 	}
-	statusf(colorBrGreen, "exec", "%s %s in castle '%s'", args[0], strings.Join(cmdArgs, " "), castle.name)
-	c := exec.Command(args[0], cmdArgs...)
-	c.Dir = castle.path
-	c.Stdin = os.Stdin
-	c.Stderr = os.Stderr
-	c.Stdout = os.Stdout
-	if err := c.Run(); err != nil {
-		if _, ok := err.(*exec.ExitError); !ok {
-			fatalf("failed to run command: %v", err)
+	for _, c := range castles {
+		if before[c.Name] {
+			continue
+		}
+		if flagRunHooks {
+			if err := home.TrustHooks(c.Name, true); err != nil {
+				return err
+			}
+		}
+		if err := c.RunHook(homesick.HookPostClone, nil); err != nil {
+			return fmt.Errorf("castle '%s': %v", c.Name, err)
 		}
 	}
+	return nil
 }
 
-func cmdExec(cmd *cobra.Command, args []string) {
-	castle := castleFromArgs(args)
-	execHelper(castle, args[1:])
+func cmdCommit(cmd *cobra.Command, args []string) {
+	var castles []*homesick.Castle
+	if flagAll {
+		castles = mustAllCastles()
+	} else {
+		var name []string
+		if len(args) > 0 {
+			name, args = args[:1], args[1:]
+		}
+		castles = castlesFromArgs(name)
+	}
+
+	commitMsg := strings.Join(args, " ")
+
+	var fail bool
+	for _, c := range castles {
+		if _, err := c.Commit(commitMsg, flagCommitUntracked, flagCommitEdit); err != nil {
+			errorf("failed to commit castle '%s': %v", c.Name, err)
+			fail = true
+		}
+	}
+	if fail {
+		os.Exit(1)
+	}
 }
 
-func cmdExecAll(cmd *cobra.Command, args []string) {
-	for _, c := range mustAllCastles() {
-		execHelper(c, args)
+func cmdDiff(cmd *cobra.Command, args []string) {
+	for _, castle := range castlesFromArgs(args) {
+		status(colorBrGreen, "git diff", castle.Name)
+		if err := home.Git().Diff(castle.Path, os.Stdout); err != nil {
+			fatalf("failed to diff: %v", err)
+		}
 	}
 }
 
 func cmdGenerate(cmd *cobra.Command, args []string) {
 	path := args[0]
+	files := args[1:]
+	if len(files) > 0 && !flagGenerateTrack {
+		fatalf("files can only be given with --track")
+	}
+
+	// tracked files are linked from the castle so it has to be where castles
+	// are looked up
+	var name string
+	if flagGenerateTrack {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			fatalf("failed to get absolute path: %v", err)
+		}
+		name = filepath.Base(abs)
+		if castlePath, _ := filepath.Abs(home.CastlePath(name)); abs != castlePath {
+			fatalf("--track needs the castle to be generated in %s", home.CastleRoot())
+		}
+	}
 
 	createDir(path)
 
-	if isGitDir(path) {
+	if home.Git().IsRepo(path) {
 		status(colorBrBlue, "git init", "already initalized")
 	} else {
 		status(colorBrGreen, "git init", path)
-		if err := gitInit(path); err != nil {
+		if err := home.Git().Init(path); err != nil {
 			fatalf("failed to git init: %v", err)
 		}
 	}
 
 	// If a github user is defined add it as the default remote.  This is a bit
 	// weird but it's what homesick does.
-	ghUser, _ := gitConfig("/", "github.user")
+	ghUser, _ := home.Git().Config("/", "github.user")
 	if ghUser != "" {
 		url := "https://github.com/" + ghUser + "/" + filepath.Base(path) + ".git"
 
-		if gitRemoteExists(path, "origin") {
+		if home.Git().RemoteExists(path, "origin") {
 			statusf(colorBrBlue, "git remote", "%s already exists", "origin")
 		} else {
 			statusf(colorBrGreen, "git remote", "add %s %s", "origin", url)
-			if err := gitRemoteAdd(path, "origin", url); err != nil {
+			if err := home.Git().RemoteAdd(path, "origin", url); err != nil {
 				fatalf("failed to add remote: %v", err)
 			}
 		}
 	}
 
 	createDir(filepath.Join(path, "home"))
+
+	if _, err := home.Scaffold(path, flagGenerateTemplate); err != nil {
+		fatalf("%v", err)
+	}
+
+	if len(files) == 0 {
+		return
+	}
+
+	castle, err := home.Castle(name)
+	if err != nil {
+		fatalf("failed to load castle '%s': %v", name, err)
+	}
+	state, err := home.LoadState()
+	if err != nil {
+		fatalf("failed to load state: %v", err)
+	}
+	if _, err := castle.Adopt(state, files); err != nil {
+		fatalf("%v", err)
+	}
 }
 
 const conflictHelp = `	Y - yes, overwrite
 	n - no, do not overwrite
 	a - all, overwrite this and all other
-	q - quit, abort
+	q - quit, abort without linking the castle
 	d - diff, show the differences between old and new
-	h - help, show this help
-`
-
-func conflictPrompt(oldfile, newfile string) (skip bool, yesAll bool) {
-	scanner := bufio.NewScanner(os.Stdin)
+	m - merge, merge the home file into the castle with $MERGETOOL and overwrite
+	b - backup, back up the home file and overwrite it
+	i - import, adopt the home file into the castle in place of its version
+	h - help, show this help`
 
+func conflictPrompt(c homesick.Conflict) homesick.ConflictDecision {
+	var err error
 	for {
-		fmt.Printf("Overwrite %s? (enter 'h' for help) [Ynaqdh] ", newfile)
+		fmt.Printf("Overwrite %s? (enter 'h' for help) [Ynaqdmbih] ", c.Target)
 
-		if ok := scanner.Scan(); !ok {
+		var answer string
+		if answer, err = readLine(); err != nil {
 			break
 		}
 
-		switch scanner.Text() {
+		switch answer {
 		case "N", "n":
-			return true, false
+			return homesick.ConflictSkip
 		case "a", "A":
-			return false, true
+			return homesick.ConflictOverwriteAll
+		case "q", "Q":
+			return homesick.ConflictQuit
 		case "d", "D":
-			if err := diffFile(oldfile, newfile); err != nil {
+			if c.Secret {
+				errorf("%s is an encrypted secret and can't be diffed", c.Source)
+				continue
+			}
+			if err := diffFile(c.Source, c.Target); err != nil {
 				errorf("failed to diff files: %v", err)
 			}
+		case "m", "M":
+			if c.Secret {
+				errorf("%s is an encrypted secret and can't be merged", c.Source)
+				continue
+			}
+			if !c.Mergeable {
+				errorf("only files can be merged")
+				continue
+			}
+			return homesick.ConflictMerge
+		case "b", "B":
+			return homesick.ConflictBackup
+		case "i", "I":
+			if c.Secret {
+				errorf("%s is an encrypted secret, use `secret add` to replace it", c.Source)
+				continue
+			}
+			return homesick.ConflictAdopt
 		case "h", "H":
 			fmt.Println(conflictHelp)
 		// default is 'Y'
 		default:
-			return false, false
+			return homesick.ConflictOverwrite
 		}
 	}
-	if err := scanner.Err(); err != nil {
+	if err != io.EOF {
 		fatalf("failed to read input: %v", err)
 	}
 
 	fatalf("prompt failed specacularly!")
-	return false, false
+	return homesick.ConflictQuit
 }
 
 func cmdLink(cmd *cobra.Command, args []string) {
-	castle := castleFromArgs(args)
+	castles := profileCastles(args)
 
-	links, subdirs, err := castle.linkables()
+	state, err := home.LoadState()
 	if err != nil {
-		fatalf("failed to find links: %v", err)
+		fatalf("failed to load state: %v", err)
 	}
 
-	for _, subdir := range subdirs {
-		subdir := filepath.Join(homeDir, subdir)
-
-		err := os.MkdirAll(subdir, 0755)
-		if os.IsExist(err) {
-			fi, err := os.Lstat(subdir)
-			if err != nil {
-				fatalf("failed to read subdir '%s': %v", subdir, err)
-			}
+	// dependencies are linked first so castles can override their files
+	castles, err = home.DependencyOrder(castles, false)
+	if err != nil {
+		fatalf("%v", err)
+	}
 
-			if !fi.IsDir() {
-				fatalf("subdir '%s' already exists but isn't a directory", subdir)
-			}
-			status(colorBrBlue, "exists", subdir)
-		} else if err != nil {
-			fatalf("failed to create subdir '%s': %v", subdir, err)
+	for _, castle := range castles {
+		// --deep covers the dependencies too so they don't link directories
+		// the named castles add files to
+		castle.Deep = castle.Deep || flagDeep
+		if _, err := castle.Link(state, conflictPrompt); err != nil {
+			fatalf("%v", err)
 		}
-		status(colorBrGreen, "mkdir", subdir)
 	}
+}
 
-	castleHome := castle.homePath()
-
-	allYes := false
-
-	for _, link := range links {
-		oldname := filepath.Join(castleHome, link)
-		newname := filepath.Join(homeDir, link)
+func cmdUnlink(cmd *cobra.Command, args []string) {
+	castles := castlesFromArgs(args)
 
-		var skip bool // skip if there is a conflict
+	state, err := home.LoadState()
+	if err != nil {
+		fatalf("failed to load state: %v", err)
+	}
 
-		if fi, err := os.Lstat(newname); err == nil {
-			if fi.Mode()&os.ModeSymlink != 0 {
-				existingLink, err := os.Readlink(newname)
-				if err != nil {
-					fatalf("failed to real link: %v", err)
-				}
-				if existingLink == oldname {
-					status(colorBrBlue, "identical", oldname)
-					continue
-				}
-			}
+	for _, castle := range castles {
+		if _, err := castle.Unlink(state); err != nil {
+			fatalf("%v", err)
+		}
+	}
+}
 
-			if !allYes {
-				statusf(colorBrRed, "conflict", "%s exists", oldname)
-				skip, allYes = conflictPrompt(oldname, newname)
-			}
+func cmdTrust(cmd *cobra.Command, args []string) {
+	for _, castle := range castlesFromArgs(args) {
+		if err := home.TrustHooks(castle.Name, !flagTrustRevoke); err != nil {
+			fatalf("%v", err)
 		}
+		if flagTrustRevoke {
+			status(colorBrBlue, "untrust", castle.Name)
+		} else {
+			status(colorBrGreen, "trust", castle.Name)
+		}
+	}
+}
 
-		if !skip {
-			statusf(colorBrGreen, "symlink", "%s to %s", oldname, newname)
-			if err := os.RemoveAll(newname); err != nil {
-				errorf("failed to remove old file: %v", err)
-			}
+func cmdPrune(cmd *cobra.Command, args []string) {
+	state, err := home.LoadState()
+	if err != nil {
+		fatalf("failed to load state: %v", err)
+	}
 
-			if err := os.Symlink(oldname, newname); err != nil {
-				errorf("failed to symlink file: %v", err)
-			}
-		}
+	if err := home.Prune(state); err != nil {
+		fatalf("%v", err)
 	}
 }
 
 func cmdList(cmd *cobra.Command, args []string) {
 	for _, c := range mustAllCastles() {
-		remote, err := c.remote()
+		remote, err := c.Remote()
 		if err != nil {
-			statusf(colorBrRed, c.name, "failed to get remote uri: %v", err)
+			statusf(colorBrRed, c.Name, "failed to get remote uri: %v", err)
 			continue
 		}
-		status(colorBrCyan, c.name, remote)
+		status(colorBrCyan, c.Name, remote)
 	}
 }
 
 func cmdOpen(cmd *cobra.Command, args []string) {
-	editor := os.Getenv("EDITOR")
+	editor := os.Getenv("VISUAL")
 	if editor == "" {
-		fatalf("The $EDITOR enviroment variable must be set to use this command")
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
 	}
 
 	castle := castleFromArgs(args)
 
-	statusf(colorBrGreen, editor, "Opening the root directory of castle '%s' in editor '%s'", castle, editor)
-	c := exec.Command(editor, castle.path)
-	c.Dir = castle.path
+	target := castle.Path
+	if len(args) > 1 {
+		path, err := castleFile(castle, args[1])
+		if err != nil {
+			fatalf("%v", err)
+		}
+		target = path
+		statusf(colorBrGreen, "open", "Opening '%s' from castle '%s' in editor '%s'", args[1], castle.Name, editor)
+	} else {
+		statusf(colorBrGreen, "open", "Opening the root directory of castle '%s' in editor '%s'", castle.Name, editor)
+	}
+
+	// the editor is run by the shell so values like `code -w` work
+	c := exec.Command("sh", "-c", editor+` "$@"`, "sh", target)
+	c.Dir = castle.Path
 	c.Stdin = os.Stdin
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
@@ -437,59 +814,209 @@ func cmdOpen(cmd *cobra.Command, args []string) {
 	if err := c.Run(); err != nil {
 		fatalf("failed to open editor: %v", err)
 	}
+}
 
+// castleFile returns the path in the castle for a file given either relative
+// to the castle's home directory (i.e. `.vimrc`) or as its path in the home
+// directory (i.e. `~/.vimrc`).
+func castleFile(castle *homesick.Castle, file string) (string, error) {
+	rel := file
+	if filepath.IsAbs(file) {
+		var err error
+		if rel, err = filepath.Rel(home.Dir(), file); err != nil {
+			return "", fmt.Errorf("'%s' isn't in the home directory", file)
+		}
+	}
+	rel = filepath.Clean(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("'%s' isn't in the home directory", file)
+	}
+
+	path := filepath.Join(castle.HomePath(), rel)
+	if _, err := os.Lstat(path); err != nil {
+		return "", fmt.Errorf("'%s' isn't in castle '%s'", rel, castle.Name)
+	}
+	return path, nil
 }
 
 func cmdPath(cmd *cobra.Command, args []string) {
 	castle := castleFromArgs(args)
-	fmt.Println(castle.path)
+	fmt.Println(castle.Path)
 }
 
 func cmdPull(cmd *cobra.Command, args []string) {
-	var castles []*castle
+	var castles []*homesick.Castle
 	if flagAll {
 		castles = mustAllCastles()
 	} else {
-		castles = []*castle{castleFromArgs(args)}
+		castles = profileCastles(args)
 	}
 
 	var fail bool
 	for _, c := range castles {
-		remote, err := c.remote()
+		if _, err := c.Pull(); err != nil {
+			errorf("%v", err)
+			fail = true
+		}
+	}
+	if fail {
+		os.Exit(1)
+	}
+}
+
+func cmdPush(cmd *cobra.Command, args []string) {
+	var castles []*homesick.Castle
+	if flagAll {
+		castles = mustAllCastles()
+	} else {
+		castles = castlesFromArgs(args)
+	}
+
+	// a single castle is pushed as is so git can prompt and report problems
+	// like a missing upstream itself
+	if !flagAll && len(castles) == 1 {
+		if err := castles[0].Push(); err != nil {
+			fatalf("failed to push castle: %v", err)
+		}
+		return
+	}
+
+	var (
+		fail       bool
+		preflights []*pushPreflight
+	)
+	for _, c := range castles {
+		p, err := preflightCastle(c, !flagPushNoFetch)
 		if err != nil {
-			errorf("failed to get remote for castle: %v", err)
+			errorf("failed to check castle '%s': %v", c.Name, err)
 			fail = true
 			continue
 		}
 
-		statusf(colorBrGreen, "git pull", "%s to castle '%s'", remote, c)
-		if err := c.update(); err != nil {
-			errorf("failed to update castle: %v", err)
+		switch p.state {
+		case pushAhead:
+			status(colorBrCyan, c.Name, p.String())
+		case pushUpToDate:
+			status(colorBrBlue, c.Name, p.String())
+		default:
+			status(colorBrRed, c.Name, p.String())
 			fail = true
 		}
+		preflights = append(preflights, p)
 	}
+
+	for _, r := range pushCastles(preflights) {
+		if !r.preflight.needsPush() {
+			continue
+		}
+		name := r.preflight.castle.Name
+		if r.err != nil {
+			statusf(colorBrRed, "rejected", "castle '%s': %v", name, r.err)
+			fail = true
+			continue
+		}
+		statusf(colorBrGreen, "git push", "castle '%s'", name)
+	}
+
 	if fail {
 		os.Exit(1)
 	}
 }
 
-func cmdPush(cmd *cobra.Command, args []string) {
+func cmdRC(cmd *cobra.Command, args []string) {
 	castle := castleFromArgs(args)
-	if err := castle.push(); err != nil {
-		fatalf("failed to push castle: %v", err)
+
+	sum, err := castle.RCChecksum()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if sum == "" {
+		fatalf("castle '%s' doesn't have a .homesickrc", castle.Name)
+	}
+
+	if _, err := castle.RunRC(); err != nil {
+		fatalf("%v", err)
+	}
+
+	// record the run so bootstrap doesn't run it again until it changes
+	state, err := home.LoadState()
+	if err != nil {
+		fatalf("failed to load state: %v", err)
+	}
+	state.RecordRC(castle.Name, sum)
+	if err := state.Save(); err != nil {
+		fatalf("failed to save state: %v", err)
 	}
 }
 
-func cmdRC(cmd *cobra.Command, args []string) {
-	fatalf("rc not implemented yet in heartsick")
+func cmdSecretAdd(cmd *cobra.Command, args []string) {
+	castle := castleFromArgs(args[1:])
+
+	absPath, err := filepath.Abs(args[0])
+	if err != nil {
+		fatalf("failed to get absolute path: %v", err)
+	}
+
+	name, err := filepath.Rel(home.Dir(), absPath)
+	if err != nil || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		fatalf("'%s' isn't in the home directory", absPath)
+	}
+
+	fi, err := os.Lstat(absPath)
+	if err != nil {
+		fatalf("failed to read secret: %v", err)
+	}
+	if !fi.Mode().IsRegular() {
+		fatalf("'%s' must be a regular file to be added as a secret", absPath)
+	}
+
+	data, err := ioutil.ReadFile(absPath)
+	if err != nil {
+		fatalf("failed to read secret: %v", err)
+	}
+
+	// asked for up front so a mistyped passphrase is caught before encrypting
+	if _, err := confirmedSecretKey(); err != nil {
+		fatalf("%v", err)
+	}
+
+	statusf(colorBrGreen, "encrypt", "%s to %s", absPath, castle.SecretPath(name))
+	if err := castle.AddSecret(name, data); err != nil {
+		fatalf("failed to add secret: %v", err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(castle.HomePath(), name)); err == nil {
+		statusf(colorBrRed, "warning", "a plaintext copy of '%s' exists in castle '%s' and should be removed", name, castle.Name)
+	}
+
+	if err := os.Chmod(absPath, 0600); err != nil {
+		errorf("failed to set permissions on '%s': %v", absPath, err)
+	}
+
+	// the file in the home directory now matches the secret so it can be
+	// managed by link.
+	state, err := home.LoadState()
+	if err != nil {
+		fatalf("failed to load state: %v", err)
+	}
+	state.RecordFile(castle.Name, homesick.StrategyDecrypt, castle.SecretPath(name), absPath, homesick.Checksum(data))
+	if err := state.Save(); err != nil {
+		fatalf("failed to save state: %v", err)
+	}
 }
 
 func cmdShell(cmd *cobra.Command, args []string) {
 	castle := castleFromArgs(args)
 
-	statusf(colorBrGreen, "shell", "Opening new shell in '%s To return to the original one exit from the new shell.", castle.path)
-	c := exec.Command(os.Getenv("SHELL"))
-	c.Dir = castle.path
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	statusf(colorBrGreen, "shell", "Opening new shell in '%s'.  To return to the original one exit from the new shell (or use `init` to cd in place).", castle.Path)
+	c := exec.Command(shell)
+	c.Dir = castle.Path
+	c.Env = castle.Environ()
 	c.Stdin = os.Stdin
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
@@ -500,11 +1027,51 @@ func cmdShell(cmd *cobra.Command, args []string) {
 }
 
 func cmdStatus(cmd *cobra.Command, args []string) {
-	castle := castleFromArgs(args)
+	for _, castle := range profileCastles(args) {
+		statusf(colorBrGreen, "git status", "%s for castle '%s'", castle.Path, castle.Name)
+		if err := home.Git().Status(castle.Path, os.Stdout); err != nil {
+			fatalf("failed to get status: %v", err)
+		}
+	}
+}
+
+func cmdAdopt(cmd *cobra.Command, args []string) {
+	castle := castleFromArgs(args[:1])
+	paths := args[1:]
+
+	state, err := home.LoadState()
+	if err != nil {
+		fatalf("failed to load state: %v", err)
+	}
+
+	if len(paths) == 0 {
+		conflicts, err := castle.Conflicts(state)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if len(conflicts) == 0 {
+			statusf(colorBrBlue, "adopt", "nothing in the way of castle '%s'", castle.Name)
+			return
+		}
+
+		for _, conflict := range conflicts {
+			fmt.Printf("Adopt %s into castle '%s'? [yN] ", conflict, castle.Name)
+			answer, err := readLine()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fatalf("failed to read input: %v", err)
+			}
+			switch answer {
+			case "y", "Y":
+				paths = append(paths, conflict)
+			}
+		}
+	}
 
-	statusf(colorBrGreen, "git status", "%s for castle '%s", castle.path, castle.name)
-	if err := gitStatus(castle.path); err != nil {
-		fatalf("failed to get status: %v", err)
+	if _, err := castle.Adopt(state, paths); err != nil {
+		fatalf("%v", err)
 	}
 }
 
@@ -516,7 +1083,7 @@ func cmdTrack(cmd *cobra.Command, args []string) {
 		fatalf("failed to get absolute path: %v", err)
 	}
 
-	relPath, err := filepath.Rel(homeDir, absPath)
+	relPath, err := filepath.Rel(home.Dir(), absPath)
 	if err != nil {
 		fatalf("failed to get absolute path: %v", err)
 	}
@@ -536,7 +1103,7 @@ func createDir(path string) {
 	}
 
 	status(colorBrGreen, "create", path)
-	if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+	if err := os.MkdirAll(path, 0755); err != nil {
 		fatalf("failed to create directory '%s': %v", path, err)
 	}
 }
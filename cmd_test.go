@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nemith/heartsick/homesick"
+)
+
+func TestCmdCloneDeps(t *testing.T) {
+	fake := useFakeGit(t)
+
+	fake.remotes["https://example.com/team.git"] = &fakeRemote{
+		files: map[string]string{
+			".homesick_deps": "[tools]\nuri = https://example.com/tools.git\nref = v2\n",
+			"home/.teamrc":   "",
+		},
+	}
+	fake.remotes["https://example.com/tools.git"] = &fakeRemote{
+		files: map[string]string{
+			".homesick_deps": "[base]\nuri = https://example.com/base.git\n",
+		},
+		refs: map[string]string{"v2": "2"},
+	}
+	fake.remotes["https://example.com/base.git"] = &fakeRemote{
+		files: map[string]string{"home/.baserc": ""},
+	}
+
+	cmdClone(nil, []string{"https://example.com/team.git"})
+
+	for _, name := range []string{"team", "tools", "base"} {
+		if _, err := os.Stat(home.CastlePath(name)); err != nil {
+			t.Errorf("castle '%s' wasn't cloned: %v", name, err)
+		}
+	}
+
+	if head := fake.repos[home.CastlePath("tools")].head; head != "2" {
+		t.Errorf("tools wasn't checked out at v2, got head %s", head)
+	}
+
+	castles, err := home.DependencyOrder(castlesFromArgs([]string{"team"}), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, c := range castles {
+		names = append(names, c.Name)
+	}
+	if len(names) != 3 || names[0] != "base" || names[1] != "tools" || names[2] != "team" {
+		t.Errorf("wrong link order: %v", names)
+	}
+}
+
+func TestCmdLinkDeepDeps(t *testing.T) {
+	fake := useFakeGit(t)
+
+	fake.remotes["https://example.com/team.git"] = &fakeRemote{
+		files: map[string]string{
+			".homesick_deps":      "[base]\nuri = https://example.com/base.git\n",
+			"home/.config/teamrc": "",
+		},
+	}
+	fake.remotes["https://example.com/base.git"] = &fakeRemote{
+		files: map[string]string{"home/.config/baserc": ""},
+	}
+	cmdClone(nil, []string{"https://example.com/team.git"})
+
+	flagDeep = true
+	defer func() { flagDeep = false }()
+	cmdLink(nil, []string{"team"})
+
+	// the dependency is linked deep as well so both castles fit in .config
+	if fi, err := os.Lstat(filepath.Join(home.Dir(), ".config")); err != nil || !fi.IsDir() {
+		t.Fatalf(".config isn't a real directory: %v", err)
+	}
+	for _, name := range []string{"teamrc", "baserc"} {
+		if _, err := os.Readlink(filepath.Join(home.Dir(), ".config", name)); err != nil {
+			t.Errorf("%s wasn't linked: %v", name, err)
+		}
+	}
+}
+
+func TestCmdCloneHooks(t *testing.T) {
+	fake := useFakeGit(t)
+
+	hook := map[string]string{"hooks/post-clone": "#!/bin/sh\ntouch hook.ran\n"}
+	fake.remotes["https://example.com/untrusted.git"] = &fakeRemote{files: hook}
+	fake.remotes["https://example.com/trusted.git"] = &fakeRemote{files: hook}
+
+	// hooks from a fresh clone are only run when asked for
+	cmdClone(nil, []string{"https://example.com/untrusted.git"})
+	if _, err := os.Stat(filepath.Join(home.CastlePath("untrusted"), "hook.ran")); err == nil {
+		t.Error("post-clone hook ran without --run-hooks")
+	}
+
+	flagRunHooks = true
+	defer func() { flagRunHooks = false }()
+	cmdClone(nil, []string{"https://example.com/trusted.git"})
+	if _, err := os.Stat(filepath.Join(home.CastlePath("trusted"), "hook.ran")); err != nil {
+		t.Errorf("post-clone hook didn't run with --run-hooks: %v", err)
+	}
+}
+
+func TestCastleFile(t *testing.T) {
+	fake := useFakeGit(t)
+
+	fake.remotes["https://example.com/dotfiles.git"] = &fakeRemote{
+		files: map[string]string{"home/.vimrc": "", "home/.config/foo/config": ""},
+	}
+	castle, err := home.Clone("https://example.com/dotfiles.git", "dotfiles")
+	if err != nil {
+		t.Fatalf("failed to clone castle: %v", err)
+	}
+
+	tt := []struct {
+		name, file string
+		want       string
+		wantErr    bool
+	}{
+		{"relative", ".vimrc", ".vimrc", false},
+		{"nested", ".config/foo/config", ".config/foo/config", false},
+		{"home path", filepath.Join(home.Dir(), ".vimrc"), ".vimrc", false},
+		{"missing", ".bashrc", "", true},
+		{"escapes home", "../.vimrc", "", true},
+		{"outside home", "/etc/passwd", "", true},
+		{"home itself", home.Dir(), "", true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := castleFile(castle, tc.file)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := filepath.Join(castle.HomePath(), tc.want); got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestCmdGenerateTrack(t *testing.T) {
+	useFakeGit(t)
+
+	vimrc := filepath.Join(home.Dir(), ".vimrc")
+	if err := ioutil.WriteFile(vimrc, []byte("set nu\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	flagGenerateTemplate = homesick.DefaultTemplate
+	flagGenerateTrack = true
+	defer func() { flagGenerateTrack = false }()
+
+	cmdGenerate(nil, []string{home.CastlePath("new"), vimrc})
+
+	castle, err := home.Castle("new")
+	if err != nil {
+		t.Fatalf("castle wasn't generated: %v", err)
+	}
+	for _, name := range []string{"README.md", ".homesick_subdir", ".homesick_ignore", ".homesickrc", ".gitignore", "home/.vimrc"} {
+		if _, err := os.Stat(filepath.Join(castle.Path, name)); err != nil {
+			t.Errorf("missing %s: %v", name, err)
+		}
+	}
+	if dest, err := os.Readlink(vimrc); err != nil || dest != filepath.Join(castle.HomePath(), ".vimrc") {
+		t.Errorf("tracked file wasn't linked back: %s, %v", dest, err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nemith/heartsick/homesick"
+	"github.com/spf13/cobra"
+)
+
+// argKind is what a positional argument of a command is completed with.
+type argKind int
+
+const (
+	argNone argKind = iota
+	// argCastle is a single castle.  Groups aren't offered as the command
+	// only takes one castle.
+	argCastle
+	// argCastles is a castle or a `@group`.
+	argCastles
+	argFile
+	// argCastleFile is a file in the castle named by the first argument.
+	argCastleFile
+)
+
+// completeArgs returns a cobra ValidArgsFunction completing the positional
+// arguments of a command by kind.  The last kind is repeated for any further
+// arguments.
+func completeArgs(kinds ...argKind) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		kind := kinds[len(kinds)-1]
+		if len(args) < len(kinds) {
+			kind = kinds[len(args)]
+		}
+
+		switch kind {
+		case argCastle:
+			return completeCastles(toComplete, false), cobra.ShellCompDirectiveNoFileComp
+		case argCastles:
+			return completeCastles(toComplete, true), cobra.ShellCompDirectiveNoFileComp
+		case argFile:
+			return nil, cobra.ShellCompDirectiveDefault
+		case argCastleFile:
+			paths := completeCastleFiles(args[0], toComplete)
+			if len(paths) == 1 && strings.HasSuffix(paths[0], "/") {
+				return paths, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+			}
+			return paths, cobra.ShellCompDirectiveNoFileComp
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func cmdCompletion(cmd *cobra.Command, args []string) {
+	var err error
+	switch args[0] {
+	case "bash":
+		err = rootCmd.GenBashCompletionV2(os.Stdout, true)
+	case "zsh":
+		err = rootCmd.GenZshCompletion(os.Stdout)
+	case "fish":
+		err = rootCmd.GenFishCompletion(os.Stdout, true)
+	}
+	if err != nil {
+		fatalf("failed to write completion script: %v", err)
+	}
+}
+
+// completeCastles returns the cloned castles starting with toComplete along
+// with the configured groups if they are allowed.
+func completeCastles(toComplete string, groups bool) []string {
+	var names []string
+
+	castles, _ := home.Castles()
+	for _, c := range castles {
+		if strings.HasPrefix(c.Name, toComplete) {
+			names = append(names, c.Name)
+		}
+	}
+
+	if !groups {
+		return names
+	}
+	if cfg, err := home.LoadConfig(); err == nil {
+		var groups []string
+		for name := range cfg.Groups {
+			if group := homesick.GroupPrefix + name; strings.HasPrefix(group, toComplete) {
+				groups = append(groups, group)
+			}
+		}
+		sort.Strings(groups)
+		names = append(names, groups...)
+	}
+	return names
+}
+
+// completeCastleFiles returns the files and directories in a castle's home
+// directory starting with toComplete.  Directories end with a slash so they
+// can be completed further.
+func completeCastleFiles(name, toComplete string) []string {
+	c, err := home.Castle(name)
+	if err != nil {
+		return nil
+	}
+
+	dir, prefix := filepath.Split(toComplete)
+	readDir := filepath.Join(c.HomePath(), dir)
+	files, err := ioutil.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), prefix) {
+			continue
+		}
+		path := dir + f.Name()
+		if fi, err := os.Stat(filepath.Join(readDir, f.Name())); err == nil && fi.IsDir() {
+			path += "/"
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/cobra"
+)
+
+func TestComplete(t *testing.T) {
+	fake := useFakeGit(t)
+
+	fake.remotes["https://example.com/dotfiles.git"] = &fakeRemote{
+		files: map[string]string{"home/.vimrc": "", "home/.config/nvim/init.vim": ""},
+	}
+	for _, name := range []string{"dotfiles", "work", "private"} {
+		if _, err := home.Clone("https://example.com/"+name+".git", name); err != nil {
+			t.Fatalf("failed to clone castle: %v", err)
+		}
+	}
+	if err := os.MkdirAll(home.DataDir(), 0755); err != nil {
+		t.Fatalf("failed to create data dir: %v", err)
+	}
+	config := "[groups]\nwork = [work, dotfiles]\nall = [@work, private]\n"
+	if err := ioutil.WriteFile(home.ConfigPath(), []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	tt := []struct {
+		name      string
+		words     []string
+		want      []string
+		directive cobra.ShellCompDirective
+	}{
+		{"commands", []string{"pu"}, []string{"pull", "push"}, cobra.ShellCompDirectiveNoFileComp},
+		{"castles", []string{"pull", ""}, []string{"dotfiles", "private", "work", "@all", "@work"}, cobra.ShellCompDirectiveNoFileComp},
+		{"castle prefix", []string{"link", "dotfiles", "w"}, []string{"work"}, cobra.ShellCompDirectiveNoFileComp},
+		{"groups", []string{"status", "@"}, []string{"@all", "@work"}, cobra.ShellCompDirectiveNoFileComp},
+		{"single castle", []string{"show_path", ""}, []string{"dotfiles", "private", "work"}, cobra.ShellCompDirectiveNoFileComp},
+		{"no groups for single castle", []string{"cd", "@"}, nil, cobra.ShellCompDirectiveNoFileComp},
+		{"extra args", []string{"rc", "dotfiles", ""}, nil, cobra.ShellCompDirectiveNoFileComp},
+		{"flags", []string{"pull", "--a"}, []string{"--all"}, cobra.ShellCompDirectiveNoFileComp},
+		{"track file", []string{"track", ""}, nil, cobra.ShellCompDirectiveDefault},
+		{"track castle", []string{"track", ".vimrc", "d"}, []string{"dotfiles"}, cobra.ShellCompDirectiveNoFileComp},
+		{"adopt", []string{"adopt", "dotfiles", ""}, nil, cobra.ShellCompDirectiveDefault},
+		{"subcommands", []string{"secret", ""}, []string{"add"}, cobra.ShellCompDirectiveNoFileComp},
+		{"valid args", []string{"completion", "z"}, []string{"zsh"}, cobra.ShellCompDirectiveNoFileComp},
+		{"castle files", []string{"open", "dotfiles", ".v"}, []string{".vimrc"}, cobra.ShellCompDirectiveNoFileComp},
+		{"castle dirs", []string{"open", "dotfiles", ".config/"}, []string{".config/nvim/"}, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got, directive := complete(t, tc.words...)
+			if !cmp.Equal(tc.want, got) {
+				t.Errorf("wrong candidates:\n%s", cmp.Diff(tc.want, got))
+			}
+			if directive != tc.directive {
+				t.Errorf("wrong directive (got: %d, want: %d)", directive, tc.directive)
+			}
+		})
+	}
+}
+
+// complete runs cobra's completion command for the words after the program
+// name and returns the candidates and directive.
+func complete(t *testing.T, words ...string) ([]string, cobra.ShellCompDirective) {
+	t.Helper()
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	rootCmd.SetErr(ioutil.Discard)
+	rootCmd.SetArgs(append([]string{cobra.ShellCompNoDescRequestCmd}, words...))
+	defer func() {
+		rootCmd.SetOut(nil)
+		rootCmd.SetErr(nil)
+		rootCmd.SetArgs(nil)
+	}()
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("failed to complete: %v", err)
+	}
+
+	var (
+		candidates []string
+		directive  int
+	)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if strings.HasPrefix(line, ":") {
+			directive, _ = strconv.Atoi(line[1:])
+			continue
+		}
+		candidates = append(candidates, line)
+	}
+	return candidates, cobra.ShellCompDirective(directive)
+}
@@ -1,13 +1,213 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/pmezard/go-difflib/difflib"
 )
 
+// diffToolEnv names an external command used to show differences instead of
+// the builtin diff.  It is run by the shell with both paths appended.
+const diffToolEnv = "HEARTSICK_DIFFTOOL"
+
+// mergeToolEnv names the command used to merge a home file into the castle.
+// It is run by the shell like a git mergetool.cmd.
+const mergeToolEnv = "MERGETOOL"
+
+// binarySniffLen is how much of a file is checked for NUL bytes to decide if
+// it is binary, same as git.
+const binarySniffLen = 8000
+
 func diffFile(oldfile, newfile string) error {
+	if tool := os.Getenv(diffToolEnv); tool != "" {
+		return runDiffTool(tool, oldfile, newfile)
+	}
+
+	changed, err := writeDiff(os.Stdout, oldfile, newfile, colorOutput)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fmt.Println("contents are identical")
+	}
+	return nil
+}
+
+// runDiffTool will run an external diff tool.  Most diff tools exit with 1
+// when there are differences so that isn't treated as a failure.
+func runDiffTool(tool, oldfile, newfile string) error {
+	cmd := exec.Command("sh", "-c", tool+` "$@"`, "sh", oldfile, newfile)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if eerr, ok := err.(*exec.ExitError); ok && eerr.ExitCode() == 1 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %v", diffToolEnv, err)
+	}
+	return nil
+}
+
+// writeDiff will write the differences between two files or directories to
+// w.  Directories are compared recursively listing files only found on one
+// side and diffing the files found in both.  Symlinks inside directories are
+// compared by their targets instead of being followed.  Returns false if
+// there were no differences.
+func writeDiff(w io.Writer, oldpath, newpath string, color bool) (bool, error) {
+	d := &differ{w: w, color: color}
+	oldInfo, err := os.Stat(oldpath)
+	if err != nil {
+		return false, err
+	}
+	newInfo, err := os.Stat(newpath)
+	if err != nil {
+		return false, err
+	}
+	if err := d.paths(oldpath, newpath, oldInfo, newInfo); err != nil {
+		return d.changed, err
+	}
+	return d.changed, nil
+}
+
+type differ struct {
+	w       io.Writer
+	color   bool
+	changed bool
+}
+
+func (d *differ) printf(c color, msg string, v ...interface{}) {
+	line := fmt.Sprintf(msg, v...)
+	if d.color && c != colorNone {
+		line = string(c) + line + string(colorNone)
+	}
+	fmt.Fprintln(d.w, line)
+}
+
+func (d *differ) paths(oldpath, newpath string, oldInfo, newInfo os.FileInfo) error {
+	switch {
+	case oldInfo.Mode()&os.ModeSymlink != 0 || newInfo.Mode()&os.ModeSymlink != 0:
+		return d.links(oldpath, newpath, oldInfo, newInfo)
+	case oldInfo.IsDir() && newInfo.IsDir():
+		return d.dirs(oldpath, newpath)
+	case oldInfo.IsDir():
+		d.changed = true
+		d.printf(colorBrRed, "%s is a directory but %s is a file", oldpath, newpath)
+		return nil
+	case newInfo.IsDir():
+		d.changed = true
+		d.printf(colorBrRed, "%s is a file but %s is a directory", oldpath, newpath)
+		return nil
+	}
+	return d.files(oldpath, newpath)
+}
+
+func (d *differ) dirs(olddir, newdir string) error {
+	oldNames, err := dirNames(olddir)
+	if err != nil {
+		return err
+	}
+	newNames, err := dirNames(newdir)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(oldNames)+len(newNames))
+	for name := range oldNames {
+		names = append(names, name)
+	}
+	for name := range newNames {
+		if !oldNames[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		oldpath := filepath.Join(olddir, name)
+		newpath := filepath.Join(newdir, name)
+		switch {
+		case !newNames[name]:
+			d.changed = true
+			d.printf(colorBrRed, "only in %s: %s", olddir, name)
+		case !oldNames[name]:
+			d.changed = true
+			d.printf(colorBrGreen, "only in %s: %s", newdir, name)
+		default:
+			oldInfo, err := os.Lstat(oldpath)
+			if err != nil {
+				return err
+			}
+			newInfo, err := os.Lstat(newpath)
+			if err != nil {
+				return err
+			}
+			if err := d.paths(oldpath, newpath, oldInfo, newInfo); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// links compares paths where at least one side is a symlink.  Links are never
+// followed so a link back to a parent (i.e. `current -> .`) can't recurse.
+func (d *differ) links(oldpath, newpath string, oldInfo, newInfo os.FileInfo) error {
+	oldDesc, err := describePath(oldpath, oldInfo)
+	if err != nil {
+		return err
+	}
+	newDesc, err := describePath(newpath, newInfo)
+	if err != nil {
+		return err
+	}
+	if oldDesc == newDesc {
+		return nil
+	}
+	d.changed = true
+	d.printf(colorBrRed, "%s %s but %s %s", oldpath, oldDesc, newpath, newDesc)
+	return nil
+}
+
+// describePath returns what is at path for a diff message.
+func describePath(path string, fi os.FileInfo) (string, error) {
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		dest, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		return "links to " + dest, nil
+	case fi.IsDir():
+		return "is a directory", nil
+	}
+	return "is a file", nil
+}
+
+// dirNames returns the names of the entries in a directory.
+func dirNames(dir string) (map[string]bool, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(files))
+	for _, f := range files {
+		names[f.Name()] = true
+	}
+	return names, nil
+}
+
+func (d *differ) files(oldfile, newfile string) error {
 	aContent, err := ioutil.ReadFile(oldfile)
 	if err != nil {
 		return err
@@ -18,11 +218,22 @@ func diffFile(oldfile, newfile string) error {
 		return err
 	}
 
+	if bytes.Equal(aContent, bContent) {
+		return nil
+	}
+	d.changed = true
+
+	if isBinary(aContent) || isBinary(bContent) {
+		d.printf(colorNone, "binary files %s and %s differ", oldfile, newfile)
+		return nil
+	}
+
 	diff := difflib.UnifiedDiff{
 		A:        difflib.SplitLines(string(aContent)),
 		FromFile: oldfile,
 		B:        difflib.SplitLines(string(bContent)),
 		ToFile:   newfile,
+		Context:  3,
 	}
 
 	text, err := difflib.GetUnifiedDiffString(diff)
@@ -30,10 +241,30 @@ func diffFile(oldfile, newfile string) error {
 		return err
 	}
 
-	if text == "" {
-		fmt.Println("file contents are identical")
-	} else {
-		fmt.Printf(text)
+	for _, line := range strings.SplitAfter(text, "\n") {
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			d.printf(colorNone, "%s", line)
+		case strings.HasPrefix(line, "@@"):
+			d.printf(colorBrCyan, "%s", line)
+		case strings.HasPrefix(line, "-"):
+			d.printf(colorBrRed, "%s", line)
+		case strings.HasPrefix(line, "+"):
+			d.printf(colorBrGreen, "%s", line)
+		default:
+			d.printf(colorNone, "%s", line)
+		}
 	}
 	return nil
 }
+
+// isBinary guesses if content is binary by looking for a NUL byte.
+func isBinary(content []byte) bool {
+	if len(content) > binarySniffLen {
+		content = content[:binarySniffLen]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
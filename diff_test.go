@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"old/same":       "same\n",
+		"old/changed":    "a\nb\n",
+		"old/removed":    "x\n",
+		"old/sub/nested": "1\n",
+		"old/bin":        "\x00\x01",
+		"new/same":       "same\n",
+		"new/changed":    "a\nc\n",
+		"new/added":      "y\n",
+		"new/sub/nested": "2\n",
+		"new/bin":        "\x00\x02",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	// links are compared without following them
+	links := map[string]string{
+		"old/current": ".",
+		"new/current": ".",
+		"old/latest":  "v1",
+		"new/latest":  "v2",
+	}
+	for name, dest := range links {
+		if err := os.Symlink(dest, filepath.Join(dir, name)); err != nil {
+			t.Fatalf("failed to create link: %v", err)
+		}
+	}
+
+	old, new := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+
+	var buf bytes.Buffer
+	changed, err := writeDiff(&buf, old, new, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !changed {
+		t.Error("expected differences")
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"only in " + new + ": added\n",
+		"only in " + old + ": removed\n",
+		"binary files " + filepath.Join(old, "bin") + " and " + filepath.Join(new, "bin") + " differ\n",
+		"-b\n+c\n",
+		"-1\n+2\n",
+		filepath.Join(old, "latest") + " links to v1 but " + filepath.Join(new, "latest") + " links to v2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("diff is missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "same") || strings.Contains(out, "current") {
+		t.Errorf("identical files shouldn't be in the diff:\n%s", out)
+	}
+
+	buf.Reset()
+	if changed, _ := writeDiff(&buf, filepath.Join(old, "same"), filepath.Join(new, "same"), false); changed || buf.Len() != 0 {
+		t.Errorf("expected identical files to have no diff: %s", buf.String())
+	}
+
+	buf.Reset()
+	if _, err := writeDiff(&buf, old, filepath.Join(new, "same"), false); err != nil || !strings.Contains(buf.String(), "is a directory") {
+		t.Errorf("expected directory/file mismatch, got: %q (%v)", buf.String(), err)
+	}
+}
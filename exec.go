@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/nemith/heartsick/homesick"
+	"github.com/spf13/cobra"
+)
+
+// execResult is the outcome of running a command in a single castle.
+type execResult struct {
+	castle *homesick.Castle
+	code   int
+	err    error
+}
+
+func cmdExec(cmd *cobra.Command, args []string) {
+	var castles []*homesick.Castle
+	if args[0] == "all" {
+		castles = mustAllCastles()
+	} else {
+		castles = castlesFromArgs(args[:1])
+	}
+	execAndExit(castles, args[1:])
+}
+
+func cmdExecAll(cmd *cobra.Command, args []string) {
+	execAndExit(mustAllCastles(), args)
+}
+
+// execAndExit runs the command in every castle and exits with the command's
+// status for a single castle or 1 if it failed in any of several castles.
+func execAndExit(castles []*homesick.Castle, args []string) {
+	results := execCastles(castles, args, flagExecParallel, os.Stdout, os.Stderr)
+
+	var failed []execResult
+	for _, r := range results {
+		if r.code != 0 {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+
+	if len(results) == 1 {
+		if failed[0].err != nil {
+			errorf("failed to run command in castle '%s': %v", failed[0].castle.Name, failed[0].err)
+		}
+		os.Exit(failed[0].code)
+	}
+
+	fmt.Println()
+	for _, r := range failed {
+		if r.err != nil {
+			statusf(colorBrRed, "failed", "castle '%s': %v", r.castle.Name, r.err)
+			continue
+		}
+		statusf(colorBrRed, "failed", "castle '%s' exited with %d", r.castle.Name, r.code)
+	}
+	os.Exit(1)
+}
+
+// execCastles runs the command in the root of every castle.  In parallel
+// each line of output is prefixed with the castle's name and stdin isn't
+// available.  Results are returned in the same order as the castles.
+func execCastles(castles []*homesick.Castle, args []string, parallel bool, stdout, stderr io.Writer) []execResult {
+	results := make([]execResult, len(castles))
+
+	if !parallel {
+		for i, c := range castles {
+			statusf(colorBrGreen, "exec", "%s in castle '%s'", strings.Join(args, " "), c.Name)
+			results[i] = execCastle(c, args, os.Stdin, stdout, stderr)
+		}
+		return results
+	}
+
+	var width int
+	for _, c := range castles {
+		if len(c.Name) > width {
+			width = len(c.Name)
+		}
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for i, c := range castles {
+		prefix := fmt.Sprintf("%-*s | ", width, c.Name)
+		out := &prefixWriter{mu: &mu, w: stdout, prefix: prefix}
+		errOut := &prefixWriter{mu: &mu, w: stderr, prefix: prefix}
+
+		wg.Add(1)
+		go func(i int, c *homesick.Castle) {
+			defer wg.Done()
+			results[i] = execCastle(c, args, nil, out, errOut)
+			out.Flush()
+			errOut.Flush()
+		}(i, c)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// execCastle runs the command in the root of a castle.  A single argument is
+// run by the shell so pipes and quoting work (i.e. `exec all 'git log | head'`),
+// otherwise the arguments are run as they are.
+func execCastle(castle *homesick.Castle, args []string, stdin io.Reader, stdout, stderr io.Writer) execResult {
+	var c *exec.Cmd
+	if len(args) == 1 {
+		c = exec.Command("sh", "-c", args[0])
+	} else {
+		c = exec.Command(args[0], args[1:]...)
+	}
+	c.Dir = castle.Path
+	c.Env = castle.Environ()
+	c.Stdin = stdin
+	c.Stdout = stdout
+	c.Stderr = stderr
+
+	err := c.Run()
+	if eerr, ok := err.(*exec.ExitError); ok {
+		code := eerr.ExitCode()
+		if code < 0 {
+			// killed by a signal
+			return execResult{castle: castle, code: 1, err: err}
+		}
+		return execResult{castle: castle, code: code}
+	}
+	if err != nil {
+		return execResult{castle: castle, code: 1, err: err}
+	}
+	return execResult{castle: castle}
+}
+
+// prefixWriter writes every line to w with a prefix.  Incomplete lines are
+// held until they are finished or Flush is called.  Writers sharing mu never
+// interleave their lines.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes any incomplete line.
+func (p *prefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	line := append(p.buf, '\n')
+	p.buf = nil
+	return p.writeLine(line)
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := io.WriteString(p.w, p.prefix+string(line))
+	return err
+}
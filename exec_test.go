@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/nemith/heartsick/homesick"
+)
+
+func TestExecCastles(t *testing.T) {
+	useFakeGit(t)
+
+	var castles []*homesick.Castle
+	for _, name := range []string{"dotfiles", "work"} {
+		c, err := home.Clone("https://example.com/"+name+".git", name)
+		if err != nil {
+			t.Fatalf("failed to clone castle: %v", err)
+		}
+		castles = append(castles, c)
+	}
+
+	cmd := `echo "$HEARTSICK_CASTLE $(basename "$PWD")" | tr a-z A-Z; test "$HEARTSICK_CASTLE_PATH" = "$PWD" || exit 9; [ "$HEARTSICK_CASTLE" = dotfiles ] || exit 3`
+
+	t.Run("sequential", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		results := execCastles(castles, []string{cmd}, false, &stdout, &stderr)
+
+		if want := "DOTFILES DOTFILES\nWORK WORK\n"; stdout.String() != want {
+			t.Errorf("wrong output:\n%s", cmp.Diff(want, stdout.String()))
+		}
+		if results[0].code != 0 || results[1].code != 3 {
+			t.Errorf("wrong exit codes: %d, %d", results[0].code, results[1].code)
+		}
+	})
+
+	t.Run("parallel", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		results := execCastles(castles, []string{cmd}, true, &stdout, &stderr)
+
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		sort.Strings(lines)
+		want := []string{"dotfiles | DOTFILES DOTFILES", "work     | WORK WORK"}
+		if !cmp.Equal(want, lines) {
+			t.Errorf("wrong output:\n%s", cmp.Diff(want, lines))
+		}
+		if results[0].castle != castles[0] || results[0].code != 0 || results[1].code != 3 {
+			t.Errorf("wrong results: %+v", results)
+		}
+	})
+
+	t.Run("arguments", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		results := execCastles(castles[:1], []string{"echo", "a | b"}, false, &stdout, &stderr)
+		if stdout.String() != "a | b\n" || results[0].code != 0 {
+			t.Errorf("arguments were run by the shell: %q", stdout.String())
+		}
+
+		results = execCastles(castles[:1], []string{"heartsick-no-such-command", "x"}, false, &stdout, &stderr)
+		if results[0].err == nil || results[0].code == 0 {
+			t.Errorf("expected a failure for a missing command, got %+v", results[0])
+		}
+	})
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		mu  sync.Mutex
+	)
+	w := &prefixWriter{mu: &mu, w: &buf, prefix: "x | "}
+
+	w.Write([]byte("one\ntw"))
+	w.Write([]byte("o\nthree"))
+	if want := "x | one\nx | two\n"; buf.String() != want {
+		t.Errorf("wrong output before flush:\n%s", cmp.Diff(want, buf.String()))
+	}
+	w.Flush()
+	if want := "x | one\nx | two\nx | three\n"; buf.String() != want {
+		t.Errorf("wrong output after flush:\n%s", cmp.Diff(want, buf.String()))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+
+	"github.com/nemith/heartsick/homesick"
+	"github.com/spf13/cobra"
+)
+
+func cmdExport(cmd *cobra.Command, args []string) {
+	out := args[0]
+
+	var castles []*homesick.Castle
+	if flagAll {
+		castles = mustAllCastles()
+	} else {
+		castles = castlesFromArgs(args[1:])
+	}
+	if len(castles) == 0 {
+		fatalf("no castles to export")
+	}
+
+	exported, err := home.Export(castles, out)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	statusf(colorBrGreen, "export", "%d castle(s) to %s", len(exported), out)
+}
+
+func cmdImport(cmd *cobra.Command, args []string) {
+	before := castleNames()
+	results, err := home.Import(args[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	var fail bool
+	for _, r := range results {
+		name := r.Castle.Name
+		switch {
+		case r.Err != nil:
+			statusf(colorBrRed, "failed", "castle '%s': %v", name, r.Err)
+			fail = true
+		case r.Cloned:
+			statusf(colorBrGreen, "imported", "castle '%s'", name)
+		case r.Updated:
+			statusf(colorBrGreen, "fast-forward", "castle '%s'", name)
+		default:
+			statusf(colorBrBlue, "up to date", "castle '%s'", name)
+		}
+	}
+	if err := postCloneHooks(before); err != nil {
+		errorf("%v", err)
+		fail = true
+	}
+	if fail {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nemith/heartsick/homesick"
+)
+
+func TestExportImport(t *testing.T) {
+	fake := useFakeGit(t)
+
+	c, err := home.Clone("https://example.com/dotfiles.git", "dotfiles")
+	if err != nil {
+		t.Fatalf("failed to clone castle: %v", err)
+	}
+	fake.repos[c.Path].head = "5"
+
+	out, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatalf("failed to create export dir: %v", err)
+	}
+	defer os.RemoveAll(out)
+
+	if _, err := home.Export([]*homesick.Castle{c}, out); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	// missing castles are cloned from the bundle with their remote restored
+	if err := os.RemoveAll(c.Path); err != nil {
+		t.Fatalf("failed to remove castle: %v", err)
+	}
+	delete(fake.repos, c.Path)
+
+	results, err := home.Import(out)
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if len(results) != 1 || !results[0].Cloned || results[0].Err != nil {
+		t.Fatalf("wrong import result: %+v", results)
+	}
+	repo := fake.repos[c.Path]
+	if repo.remote != "https://example.com/dotfiles.git" || repo.head != "5" {
+		t.Errorf("wrong castle after import (remote: %s, head: %s)", repo.remote, repo.head)
+	}
+
+	// existing castles are fast-forwarded
+	repo.head = "4"
+	results, _ = home.Import(out)
+	if !results[0].Updated || repo.head != "5" {
+		t.Errorf("castle wasn't fast-forwarded: %+v (head: %s)", results[0], repo.head)
+	}
+
+	results, _ = home.Import(out)
+	if results[0].Updated || results[0].Err != nil {
+		t.Errorf("castle should be up to date: %+v", results[0])
+	}
+
+	// diverged castles are left alone
+	repo.head = "6"
+	repo.ahead = 1
+	results, _ = home.Import(out)
+	if results[0].Err == nil || repo.head != "6" {
+		t.Errorf("expected diverged castle to fail: %+v (head: %s)", results[0], repo.head)
+	}
+	// nothing is written when a castle fails to bundle
+	failed := filepath.Join(out, "failed")
+	delete(fake.repos, c.Path)
+	if _, err := home.Export([]*homesick.Castle{c}, failed); err == nil {
+		t.Error("expected export of a broken castle to fail")
+	}
+	if files, _ := ioutil.ReadDir(out); len(files) != 2 {
+		t.Errorf("failed export left files behind: %v", files)
+	}
+}
@@ -0,0 +1,190 @@
+package homesick
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Adopt will move existing files or directories from the home directory into
+// the castle and link them back in their place.  A castle's own version of an
+// adopted path is replaced.  Parents of nested paths (i.e. `.config` for
+// `.config/foo`) are added to the castle's .homesick_subdir so only the
+// adopted path is linked.  Everything is done as a single transaction: if
+// anything fails every file is moved back and .homesick_subdir is restored.
+// Returns the targets that were linked.
+func (c Castle) Adopt(state *State, paths []string) ([]string, error) {
+	var (
+		ops  []*linkOp
+		rels []string
+	)
+	for _, path := range paths {
+		rel, err := c.adoptable(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range rels {
+			if isParentPath(rel, other) || isParentPath(other, rel) {
+				return nil, fmt.Errorf("can't adopt both '%s' and '%s'", other, rel)
+			}
+		}
+		rels = append(rels, rel)
+
+		ops = append(ops, &linkOp{
+			kind:   opSymlink,
+			source: filepath.Join(c.HomePath(), rel),
+			target: filepath.Join(c.home.dir, rel),
+			adopt:  true,
+		})
+	}
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	restore, err := c.addSubdirs(rels)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &LinkPlan{castle: &c, ops: ops}
+	if err := plan.Apply(state); err != nil {
+		if rerr := restore(); rerr != nil {
+			return nil, fmt.Errorf("failed to adopt into castle '%s': %v (failed to restore %s: %v)", c.Name, err, subdirFilename, rerr)
+		}
+		return nil, fmt.Errorf("failed to adopt into castle '%s': %v", c.Name, err)
+	}
+
+	if err := state.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save state: %v", err)
+	}
+	return plan.Changed(), nil
+}
+
+// adoptable checks that a path can be adopted into the castle and returns it
+// relative to the home directory.
+func (c Castle) adoptable(path string) (string, error) {
+	h := c.home
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	rel, err := filepath.Rel(h.dir, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("'%s' isn't in the home directory", abs)
+	}
+	if isParentPath(abs, h.DataDir()) {
+		return "", fmt.Errorf("'%s' belongs to heartsick", abs)
+	}
+
+	if _, err := os.Lstat(abs); err != nil {
+		return "", fmt.Errorf("can't adopt '%s': %v", abs, err)
+	}
+
+	// anything reached through a link into a castle is already managed
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", fmt.Errorf("can't adopt '%s': %v", abs, err)
+	}
+	if castleRoot, err := filepath.EvalSymlinks(h.castleRoot); err == nil && isParentPath(resolved, castleRoot) {
+		return "", fmt.Errorf("'%s' is already in a castle", abs)
+	}
+	return rel, nil
+}
+
+// addSubdirs will add every parent directory of the given paths to the
+// castle's .homesick_subdir if they aren't already in it.  Deep castles link
+// every file on their own so nothing is added for them.  The returned
+// function restores the file as it was.
+func (c Castle) addSubdirs(rels []string) (func() error, error) {
+	noop := func() error { return nil }
+	if c.Deep {
+		return noop, nil
+	}
+
+	subdirs, err := c.Subdirs()
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool)
+	for _, subdir := range subdirs {
+		have[subdir] = true
+	}
+
+	var add []string
+	for _, rel := range rels {
+		var parents []string
+		for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+			parents = append([]string{dir}, parents...)
+		}
+		for _, dir := range parents {
+			if !have[dir] {
+				have[dir] = true
+				add = append(add, dir)
+			}
+		}
+	}
+	if len(add) == 0 {
+		return noop, nil
+	}
+
+	path := filepath.Join(c.Path, subdirFilename)
+	orig, err := ioutil.ReadFile(path)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read subdir file '%s': %v", path, err)
+	}
+
+	data := append([]byte{}, orig...)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	for _, dir := range add {
+		c.home.statusf(StatusChange, "subdir", "%s in castle '%s'", dir, c.Name)
+		data = append(data, filepath.ToSlash(dir)+"\n"...)
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write subdir file '%s': %v", path, err)
+	}
+
+	return func() error {
+		if !existed {
+			return os.Remove(path)
+		}
+		return writeFileAtomic(path, orig, 0644)
+	}, nil
+}
+
+// Conflicts returns the existing files in the home directory that are in the
+// way of linking the castle and aren't owned by heartsick.
+func (c Castle) Conflicts(state *State) ([]string, error) {
+	links, _, err := c.Linkables()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find links: %v", err)
+	}
+
+	var conflicts []string
+	for _, link := range links {
+		source := filepath.Join(c.HomePath(), link)
+		target := filepath.Join(c.home.dir, link)
+
+		fi, err := os.Lstat(target)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s': %v", target, err)
+		}
+		op := &linkOp{kind: opSymlink, source: source, target: target}
+		if identical, _ := op.identical(fi); identical {
+			continue
+		}
+		if state.Owns(c.Name, target) {
+			continue
+		}
+		conflicts = append(conflicts, target)
+	}
+	return conflicts, nil
+}
@@ -0,0 +1,138 @@
+package homesick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdopt(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	files := map[string]string{
+		".config/foo/config": "foo",
+		".file1":             "home file1",
+		".newrc":             "new",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpHomePath, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	state, _ := h.LoadState()
+	conflicts, err := castle.Conflicts(state)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(conflicts) != 1 || conflicts[0] != filepath.Join(tmpHomePath, ".file1") {
+		t.Errorf("wrong conflicts: %v", conflicts)
+	}
+
+	paths := []string{
+		filepath.Join(tmpHomePath, ".config/foo"),
+		filepath.Join(tmpHomePath, ".file1"),
+		filepath.Join(tmpHomePath, ".newrc"),
+	}
+	if _, err := castle.Adopt(state, paths); err != nil {
+		t.Fatalf("failed to adopt: %v", err)
+	}
+
+	for name, content := range files {
+		if data, err := ioutil.ReadFile(filepath.Join(tmpHomePath, name)); err != nil || string(data) != content {
+			t.Errorf("wrong content for %s through the link (got: %q, %v)", name, data, err)
+		}
+		if data, _ := ioutil.ReadFile(filepath.Join(castle.HomePath(), name)); string(data) != content {
+			t.Errorf("castle doesn't have %s: %q", name, data)
+		}
+	}
+	if dest, _ := os.Readlink(filepath.Join(tmpHomePath, ".config/foo")); dest != filepath.Join(castle.HomePath(), ".config/foo") {
+		t.Errorf("adopted directory wasn't linked: %s", dest)
+	}
+
+	subdirs, _ := castle.Subdirs()
+	if !contains(subdirs, ".config") {
+		t.Errorf(".config wasn't added to the subdirs: %v", subdirs)
+	}
+
+	// linking again has nothing to do
+	if conflicts, _ := castle.Conflicts(state); len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts after adopting: %v", conflicts)
+	}
+
+	// already linked files can't be adopted again
+	if _, err := castle.Adopt(state, paths[:1]); err == nil || !strings.Contains(err.Error(), "already in a castle") {
+		t.Errorf("expected already adopted error, got: %v", err)
+	}
+}
+
+func TestAdoptRollback(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+	subdirFile := filepath.Join(castle.Path, subdirFilename)
+	origSubdirs, _ := ioutil.ReadFile(subdirFile)
+
+	good := filepath.Join(tmpHomePath, ".local/good")
+	if err := os.MkdirAll(filepath.Dir(good), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(good, []byte("good"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	// a castle path blocked by a file makes the second adopt fail
+	bad := filepath.Join(tmpHomePath, ".blocked/bad")
+	if err := os.MkdirAll(filepath.Dir(bad), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(bad, []byte("bad"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(castle.HomePath(), ".blocked"), nil, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	state, _ := h.LoadState()
+	if _, err := castle.Adopt(state, []string{good, bad}); err == nil {
+		t.Fatal("expected adopt to fail")
+	}
+
+	for path, content := range map[string]string{good: "good", bad: "bad"} {
+		fi, err := os.Lstat(path)
+		if err != nil || !fi.Mode().IsRegular() {
+			t.Errorf("%s wasn't restored: %v", path, err)
+			continue
+		}
+		if data, _ := ioutil.ReadFile(path); string(data) != content {
+			t.Errorf("wrong content for %s: %q", path, data)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(castle.HomePath(), ".local")); !os.IsNotExist(err) {
+		t.Errorf("created castle directory wasn't removed: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(subdirFile); string(data) != string(origSubdirs) {
+		t.Errorf("subdir file wasn't restored: %q", data)
+	}
+	if len(state.Entries) != 0 {
+		t.Errorf("state shouldn't be updated on failure: %d entries", len(state.Entries))
+	}
+}
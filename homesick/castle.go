@@ -0,0 +1,463 @@
+package homesick
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultCastle is the castle used when none is given.
+	DefaultCastle = "dotfiles"
+
+	subdirFilename = ".homesick_subdir"
+	ignoreFilename = ".homesick_ignore"
+	deepFilename   = ".homesick_deep"
+)
+
+// Castle is a git repository of dotfiles.  Everything in the `home`
+// directory of the castle is linked into the home directory.
+type Castle struct {
+	Name string
+	Path string
+
+	// Deep castles have every file linked individually in real directories
+	// instead of linking top level files and directories.
+	Deep bool
+
+	home *Home
+}
+
+// HomePath will return the `home` directory in the castle.
+func (c Castle) HomePath() string {
+	return filepath.Join(c.Path, "home")
+}
+
+// Remote returns the remote uri for a given castle.
+func (c Castle) Remote() (string, error) {
+	return c.home.git.RemoteURL(c.Path)
+}
+
+// Update will update the castle from git.
+func (c Castle) Update() error {
+	return c.home.git.Pull(c.Path)
+	// TODO(bbennett) submodules
+}
+
+// Push will push commited changes to the remote.  Output and credential
+// prompts use the streams in Options.
+func (c Castle) Push() error {
+	return c.home.git.Push(c.Path, c.home.stdout, c.home.stdin != nil)
+}
+
+// Pull will update the castle from its remote running the pull hooks.  Returns
+// the files in the castle changed by the pull.
+func (c Castle) Pull() ([]string, error) {
+	remote, err := c.Remote()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote for castle: %v", err)
+	}
+
+	if err := c.RunHook(HookPrePull, nil); err != nil {
+		return nil, fmt.Errorf("skipping castle '%s': %v", c.Name, err)
+	}
+
+	git := c.home.git
+	before, _ := git.Head(c.Path)
+
+	c.home.statusf(StatusChange, "git pull", "%s to castle '%s'", remote, c.Name)
+	if err := c.Update(); err != nil {
+		return nil, fmt.Errorf("failed to update castle: %v", err)
+	}
+
+	var changed []string
+	if after, _ := git.Head(c.Path); before != "" && after != before {
+		changed, err = git.ChangedFiles(c.Path, before, after)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find changed files: %v", err)
+		}
+	}
+
+	if err := c.RunHook(HookPostPull, changed); err != nil {
+		return changed, err
+	}
+	return changed, nil
+}
+
+// Commit will commit all changes to tracked files in the castle, first adding
+// untracked files under home/ if requested.  A message is generated if none
+// is given and the editor is opened if edit is set.  Returns the committed
+// files or nil if there was nothing to commit.
+func (c Castle) Commit(msg string, untracked, edit bool) ([]string, error) {
+	git := c.home.git
+	if untracked {
+		added, err := git.AddUntracked(c.Path, "home")
+		if err != nil {
+			return nil, fmt.Errorf("failed to add untracked files: %v", err)
+		}
+		for _, f := range added {
+			c.home.status(StatusChange, "git add", c.DisplayPath(f))
+		}
+	}
+
+	files, err := git.StatusFiles(c.Path, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status: %v", err)
+	}
+	if len(files) == 0 {
+		c.home.statusf(StatusInfo, "git commit all", "nothing to commit in castle '%s'", c.Name)
+		return nil, nil
+	}
+
+	if msg == "" {
+		msg = c.CommitMessage(files)
+	}
+
+	c.home.status(StatusChange, "git commit all", c.Name)
+	if err := git.CommitAll(c.Path, msg, edit); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// DisplayPath returns how a file in the castle's repo is shown to the user.
+// Files under `home` are shown by their path in the home directory and secrets
+// by the path they are decrypted to.
+func (c Castle) DisplayPath(file string) string {
+	file = filepath.ToSlash(file)
+	switch {
+	case strings.HasPrefix(file, "home/"):
+		return "~/" + strings.TrimPrefix(file, "home/")
+	case strings.HasPrefix(file, secretsDirname+"/") && strings.HasSuffix(file, secretExt):
+		return "~/" + strings.TrimSuffix(strings.TrimPrefix(file, secretsDirname+"/"), secretExt) + " (secret)"
+	}
+	return file
+}
+
+// CommitMessage generates a commit message for the changed files in the castle
+// listing the dotfiles by their path in the home directory.
+func (c Castle) CommitMessage(files []string) string {
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, c.DisplayPath(f))
+	}
+
+	if len(paths) <= 3 {
+		return "Update " + strings.Join(paths, ", ")
+	}
+	return fmt.Sprintf("Update %d dotfiles\n\n%s", len(paths), "- "+strings.Join(paths, "\n- "))
+}
+
+// isParentPath will return true if the path has parent as a parent.  Both
+// paths are compared component-wise after being cleaned so trailing slashes or
+// partial names (i.e `.loc` and `.local`) don't cause false matches.
+func isParentPath(parent, path string) bool {
+	parent, path = filepath.Clean(parent), filepath.Clean(path)
+	if parent == path {
+		return true
+	}
+	return strings.HasPrefix(parent, path+string(filepath.Separator))
+}
+
+// linkables is a helper for Castle.Linkables() returning a list of files to
+// be linked in a directory.  If the file/dir is a parent of any given subdirs
+// then it will be excluded.  Results are all relative to the given base
+// directory.
+func linkables(dir, base string, subdirs []string) ([]string, error) {
+	relPath, err := filepath.Rel(base, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var links []string
+Loop:
+	for _, f := range files {
+		rel := filepath.Join(relPath, f.Name())
+		for _, subdir := range subdirs {
+			if isParentPath(subdir, rel) {
+				continue Loop
+			}
+		}
+		links = append(links, rel)
+	}
+	return links, nil
+}
+
+// Linkables will find all files/directories that are eligible to be linked.
+// Only top level dir/files are linked a long with any sub-directories found in
+// the .homesick_subdir file at the top of the castle.  Deep castles are handled
+// by deepLinkables instead.  Anything matching the .homesick_ignore file is
+// left out.
+func (c Castle) Linkables() ([]string, []string, error) {
+	links, subdirs, err := c.linkables()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ignores, err := c.Ignores()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ignores) == 0 {
+		return links, subdirs, nil
+	}
+
+	keptLinks := make([]string, 0, len(links))
+	for _, link := range links {
+		if !isIgnored(link, ignores) {
+			keptLinks = append(keptLinks, link)
+		}
+	}
+	if c.Deep {
+		keptSubdirs := make([]string, 0, len(subdirs))
+		for _, subdir := range subdirs {
+			if !isIgnored(subdir, ignores) {
+				keptSubdirs = append(keptSubdirs, subdir)
+			}
+		}
+		subdirs = keptSubdirs
+	}
+	return keptLinks, subdirs, nil
+}
+
+func (c Castle) linkables() ([]string, []string, error) {
+	if c.Deep {
+		return c.deepLinkables()
+	}
+
+	subdirs, err := c.Subdirs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	baseHome := c.HomePath()
+	links, err := linkables(baseHome, baseHome, subdirs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// find all linkables for the subdirs
+	for _, subdir := range subdirs {
+		subdirLinks, err := linkables(filepath.Join(baseHome, subdir), baseHome, subdirs)
+		if err != nil {
+			return nil, nil, err
+		}
+		links = append(links, subdirLinks...)
+	}
+
+	return links, subdirs, nil
+}
+
+// deepLinkables walks the entire home directory of the castle returning every
+// file as a link and every directory as a subdir to be created.  Subdirs are
+// returned parents first so they can be created in order.
+func (c Castle) deepLinkables() ([]string, []string, error) {
+	baseHome := c.HomePath()
+
+	links := []string{}
+	subdirs := []string{}
+	err := filepath.Walk(baseHome, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == baseHome {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if path == baseHome {
+			return nil
+		}
+
+		rel, err := filepath.Rel(baseHome, path)
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			subdirs = append(subdirs, rel)
+		} else {
+			links = append(links, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return links, subdirs, nil
+}
+
+// ParseError is returned for an invalid line in one of the castle's
+// configuration files.
+type ParseError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// hasGlob returns true if the path contains any glob meta characters.
+func hasGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// cleanEntry will clean and validate a path from one of the castle's
+// configuration files.  Paths must be relative to the home directory and stay
+// inside of it.  Glob patterns are allowed.
+func cleanEntry(line string) (string, error) {
+	entry := filepath.FromSlash(line)
+	if filepath.IsAbs(entry) || strings.HasPrefix(line, "/") {
+		return "", fmt.Errorf("absolute path '%s' not allowed", line)
+	}
+
+	entry = filepath.Clean(entry)
+	for _, part := range strings.Split(entry, string(filepath.Separator)) {
+		if part == ".." {
+			return "", fmt.Errorf("path '%s' points outside of the castle", line)
+		}
+	}
+	if entry == "." {
+		return "", errors.New("the castle home itself can't be used")
+	}
+
+	if _, err := filepath.Match(entry, ""); err != nil {
+		return "", fmt.Errorf("invalid glob pattern '%s'", line)
+	}
+	return entry, nil
+}
+
+// ParseSubdirs will read subdir entries from r, one per line.  Blank lines and
+// lines starting with '#' are ignored.  Entries are cleaned (trailing slashes
+// and windows line endings are removed) and must be relative paths that stay
+// inside of the castle.  Glob patterns are validated but not expanded.
+func ParseSubdirs(r io.Reader, filename string) ([]string, error) {
+	return parseEntries(r, filename, "subdir")
+}
+
+// parseEntries reads path entries from r like ParseSubdirs.  Kind names the
+// file in errors.
+func parseEntries(r io.Reader, filename, kind string) ([]string, error) {
+	entries := []string{}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry, err := cleanEntry(line)
+		if err != nil {
+			return nil, &ParseError{filename, lineNo, err.Error()}
+		}
+
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s file '%s': %v", kind, filename, err)
+	}
+
+	return entries, nil
+}
+
+// Subdirs will read the .homesick_subdir file from the castle and return the
+// a list of directories.  Directories are defined as one per line.  Glob
+// patterns are expanded to the matching directories in the castle's home.
+func (c Castle) Subdirs() ([]string, error) {
+	subdirFile := filepath.Join(c.Path, subdirFilename)
+
+	f, err := os.Open(subdirFile)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subdir file '%s': %v", subdirFile, err)
+	}
+	defer f.Close()
+
+	entries, err := ParseSubdirs(f, subdirFile)
+	if err != nil {
+		return nil, err
+	}
+
+	baseHome := c.HomePath()
+	seen := make(map[string]bool)
+	subdirs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !hasGlob(entry) {
+			if !seen[entry] {
+				seen[entry] = true
+				subdirs = append(subdirs, entry)
+			}
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(baseHome, entry))
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand subdir '%s': %v", entry, err)
+		}
+		for _, match := range matches {
+			if fi, err := os.Stat(match); err != nil || !fi.IsDir() {
+				continue
+			}
+			rel, err := filepath.Rel(baseHome, match)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[rel] {
+				seen[rel] = true
+				subdirs = append(subdirs, rel)
+			}
+		}
+	}
+
+	return subdirs, nil
+}
+
+// Ignores will read the .homesick_ignore file from the castle and return its
+// patterns.  Paths in the castle's home matching a pattern, or inside of a
+// directory matching one, are never linked.  Patterns are only checked against
+// the paths Linkables returns so anything inside a directory that is linked
+// as a whole can't be ignored.  The file uses the same format as
+// .homesick_subdir.
+func (c Castle) Ignores() ([]string, error) {
+	ignoreFile := filepath.Join(c.Path, ignoreFilename)
+
+	f, err := os.Open(ignoreFile)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ignore file '%s': %v", ignoreFile, err)
+	}
+	defer f.Close()
+
+	return parseEntries(f, ignoreFile, "ignore")
+}
+
+// isIgnored returns true if the path or any of its parents match one of the
+// patterns.
+func isIgnored(path string, patterns []string) bool {
+	for p := path; p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,282 @@
+package homesick
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIsParentPath(t *testing.T) {
+	tt := []struct {
+		parent string
+		path   string
+		want   bool
+	}{
+		{".config", ".config", true},
+		{".config", ".bashrc", false},
+		{".local/share", ".local", true},
+		{".local/share", ".bashrc", false},
+		{".local/share", ".loc", false},
+		{".local/share/", ".local/", true},
+		{".local", ".local/share", false},
+	}
+
+	for _, tc := range tt {
+		t.Run(fmt.Sprintf("%s,%s", tc.parent, tc.path), func(t *testing.T) {
+			got := isParentPath(tc.parent, tc.path)
+
+			if got != tc.want {
+				t.Errorf("unexpected result (got: %t, want %t)", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCastleLinkables(t *testing.T) {
+	tt := []struct {
+		home, castle string
+		want         []string
+		fail         bool
+	}{
+		{"home1", "dotfiles",
+			[]string{
+				".dir1",
+				".file1",
+				".dir2/.file1",
+				".dir2/.file2",
+				".dir3/.subdir1/.file1",
+				".dir3/.subdir1/.file2",
+			}, false},
+		{"home1", "private", []string{".file1", ".file2"}, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(fmt.Sprintf("%s:%s", tc.home, tc.castle), func(t *testing.T) {
+			h, cleanup := setupHomedir(t, tc.home)
+			defer cleanup()
+
+			castle, err := h.Castle(tc.castle)
+			if err != nil {
+				t.Fatalf("failed to load castle: %v", err)
+			}
+
+			got, _, err := castle.Linkables()
+			if err != nil && !tc.fail {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if !cmp.Equal(tc.want, got) {
+				t.Errorf("wrong linkables returned:\n%s", cmp.Diff(tc.want, got))
+			}
+
+		})
+	}
+}
+
+func TestCastleDeepLinkables(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	deepFile := filepath.Join(tmpHomePath, ".homesick/repos/dotfiles", deepFilename)
+	if err := ioutil.WriteFile(deepFile, nil, 0644); err != nil {
+		t.Fatalf("failed to write deep file: %v", err)
+	}
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	if !castle.Deep {
+		t.Fatal("castle should be deep")
+	}
+
+	links, subdirs, err := castle.Linkables()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantLinks := []string{
+		".dir1/.file1",
+		".dir3/.subdir1/.file1",
+		".dir3/.subdir1/.file2",
+		".file1",
+	}
+	if !cmp.Equal(wantLinks, links) {
+		t.Errorf("wrong linkables returned:\n%s", cmp.Diff(wantLinks, links))
+	}
+
+	wantSubdirs := []string{".dir1", ".dir2", ".dir2/.file1", ".dir2/.file2", ".dir3", ".dir3/.subdir1"}
+	if !cmp.Equal(wantSubdirs, subdirs) {
+		t.Errorf("wrong subdirs returned:\n%s", cmp.Diff(wantSubdirs, subdirs))
+	}
+}
+
+func TestCastleIgnores(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	defer cleanup()
+
+	ignoreFile := filepath.Join(h.Dir(), ".homesick/repos/dotfiles", ignoreFilename)
+	if err := ioutil.WriteFile(ignoreFile, []byte("# comment\n.dir1\n.dir3/*/.file2\n"), 0644); err != nil {
+		t.Fatalf("failed to write ignore file: %v", err)
+	}
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	links, _, err := castle.Linkables()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{".file1", ".dir2/.file1", ".dir2/.file2", ".dir3/.subdir1/.file1"}
+	if !cmp.Equal(want, links) {
+		t.Errorf("wrong linkables returned:\n%s", cmp.Diff(want, links))
+	}
+
+	// deep castles skip ignored directories along with everything in them
+	castle.Deep = true
+	links, subdirs, err := castle.Linkables()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want = []string{".dir3/.subdir1/.file1", ".file1"}
+	if !cmp.Equal(want, links) {
+		t.Errorf("wrong deep linkables returned:\n%s", cmp.Diff(want, links))
+	}
+	wantSubdirs := []string{".dir2", ".dir2/.file1", ".dir2/.file2", ".dir3", ".dir3/.subdir1"}
+	if !cmp.Equal(wantSubdirs, subdirs) {
+		t.Errorf("wrong deep subdirs returned:\n%s", cmp.Diff(wantSubdirs, subdirs))
+	}
+}
+
+func TestCastleSubdirs(t *testing.T) {
+	tt := []struct {
+		home, castle string
+		want         []string
+		fail         bool
+	}{
+		{"home1", "dotfiles", []string{".dir2", ".nonexistent", ".dir3/.subdir1"}, false},
+		{"home1", "private", []string{}, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(fmt.Sprintf("%s:%s", tc.home, tc.castle), func(t *testing.T) {
+			h, cleanup := setupHomedir(t, tc.home)
+			defer cleanup()
+
+			castle, err := h.Castle(tc.castle)
+			if err != nil {
+				t.Fatalf("failed to load castle: %v", err)
+			}
+
+			got, err := castle.Subdirs()
+			if err != nil && !tc.fail {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if !cmp.Equal(tc.want, got) {
+				t.Errorf("wrong subdirs returned:\n%s", cmp.Diff(tc.want, got))
+			}
+
+		})
+	}
+}
+
+func TestParseSubdirs(t *testing.T) {
+	tt := []struct {
+		name  string
+		input string
+		want  []string
+		line  int
+	}{
+		{"simple", ".dir1\n.dir2/.sub\n", []string{".dir1", ".dir2/.sub"}, 0},
+		{"comments", "# comment\n\n  \n.dir1\n", []string{".dir1"}, 0},
+		{"crlf", ".dir1\r\n.dir2\r\n", []string{".dir1", ".dir2"}, 0},
+		{"trailing slash", ".config/\n.local//share/\n", []string{".config", ".local/share"}, 0},
+		{"glob", ".config/*\n", []string{".config/*"}, 0},
+		{"absolute", ".dir1\n/etc\n", nil, 2},
+		{"parent", "# comment\n.dir1\n../.dir2\n", nil, 3},
+		{"nested parent", ".dir1/../../.dir2\n", nil, 1},
+		{"dot", ".\n", nil, 1},
+		{"bad glob", ".config/[\n", nil, 1},
+		{"empty", "", []string{}, 0},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseSubdirs(strings.NewReader(tc.input), subdirFilename)
+			if tc.line != 0 {
+				serr, ok := err.(*ParseError)
+				if !ok {
+					t.Fatalf("expected ParseError, got: %v", err)
+				}
+				if serr.Line != tc.line {
+					t.Errorf("wrong error line (want: %d, got: %d)", tc.line, serr.Line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !cmp.Equal(tc.want, got) {
+				t.Errorf("wrong subdirs returned:\n%s", cmp.Diff(tc.want, got))
+			}
+		})
+	}
+}
+
+func TestCastleSubdirsGlob(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	subdirFile := filepath.Join(tmpHomePath, ".homesick/repos/dotfiles", subdirFilename)
+	if err := ioutil.WriteFile(subdirFile, []byte("# dirs\n.dir*\n.dir2/\n"), 0644); err != nil {
+		t.Fatalf("failed to write subdir file: %v", err)
+	}
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	got, err := castle.Subdirs()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{".dir1", ".dir2", ".dir3"}
+	if !cmp.Equal(want, got) {
+		t.Errorf("wrong subdirs returned:\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestCastleCommitMessage(t *testing.T) {
+	c := Castle{Name: "dotfiles"}
+
+	tt := []struct {
+		files []string
+		want  string
+	}{
+		{[]string{"home/.vimrc"}, "Update ~/.vimrc"},
+		{[]string{"home/.vimrc", "secrets/.netrc.enc", "README.md"}, "Update ~/.vimrc, ~/.netrc (secret), README.md"},
+		{
+			[]string{"home/.a", "home/.b", "home/.config/c", "home/.d"},
+			"Update 4 dotfiles\n\n- ~/.a\n- ~/.b\n- ~/.config/c\n- ~/.d",
+		},
+	}
+
+	for _, tc := range tt {
+		if got := c.CommitMessage(tc.files); got != tc.want {
+			t.Errorf("wrong message for %v:\n got: %q\nwant: %q", tc.files, got, tc.want)
+		}
+	}
+}
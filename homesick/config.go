@@ -0,0 +1,269 @@
+package homesick
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const configFilename = "config"
+
+// GroupPrefix marks a castle argument as the name of a group.
+const GroupPrefix = "@"
+
+// section is a `[name]` block of `key = value` lines from a config file.
+type section struct {
+	name    string
+	line    int
+	entries []entry
+}
+
+// entry is a single `key = value` line from a config file.
+type entry struct {
+	key   string
+	value string
+	line  int
+}
+
+// lookup returns the value for key in the section.
+func (s *section) lookup(key string) (entry, bool) {
+	for _, e := range s.entries {
+		if e.key == key {
+			return e, true
+		}
+	}
+	return entry{}, false
+}
+
+// parseSections will read an ini style file made of `[section]` headers
+// followed by `key = value` lines.  Blank lines and lines starting with '#'
+// are ignored.  Keys before the first header are an error and so are
+// duplicate keys within a section.
+func parseSections(r io.Reader, filename string) ([]*section, error) {
+	var (
+		sections []*section
+		cur      *section
+	)
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, &ParseError{filename, lineNo, "unterminated section header"}
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, &ParseError{filename, lineNo, "empty section name"}
+			}
+			cur = &section{name: name, line: lineNo}
+			sections = append(sections, cur)
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, &ParseError{filename, lineNo, "expected `key = value`"}
+		}
+		if cur == nil {
+			return nil, &ParseError{filename, lineNo, "key outside of a section"}
+		}
+
+		key := unquote(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+		if key == "" {
+			return nil, &ParseError{filename, lineNo, "empty key"}
+		}
+		if _, ok := cur.lookup(key); ok {
+			return nil, &ParseError{filename, lineNo, fmt.Sprintf("duplicate key '%s' in [%s]", key, cur.name)}
+		}
+		cur.entries = append(cur.entries, entry{key: key, value: value, line: lineNo})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read '%s': %v", filename, err)
+	}
+
+	return sections, nil
+}
+
+// unquote removes matching double quotes around s.
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// parseList will parse a value that is either a single item or a list of
+// items in the form of `[a, b, c]`.  Items may be quoted.
+func parseList(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") {
+		if value == "" {
+			return nil, fmt.Errorf("empty value")
+		}
+		return []string{unquote(value)}, nil
+	}
+	if !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("unterminated list '%s'", value)
+	}
+
+	items := []string{}
+	inner := strings.TrimSpace(value[1 : len(value)-1])
+	if inner == "" {
+		return items, nil
+	}
+	for _, item := range strings.Split(inner, ",") {
+		item = unquote(strings.TrimSpace(item))
+		if item == "" {
+			return nil, fmt.Errorf("empty item in list '%s'", value)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Config is the user's heartsick configuration from ~/.homesick/config.
+//
+//	[groups]
+//	work = [dotfiles, work-secrets, k8s-tools]
+//
+//	[profiles]
+//	laptop = [@work, private]
+//	default = dotfiles
+//
+// Groups name a list of castles (or other groups prefixed with `@`).
+// Profiles choose the castles used when none are given, keyed by hostname
+// with `default` used for any other host.
+type Config struct {
+	Groups   map[string][]string
+	Profiles map[string][]string
+}
+
+// ParseConfig will read a config file from r.
+func ParseConfig(r io.Reader, filename string) (*Config, error) {
+	sections, err := parseSections(r, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		Groups:   make(map[string][]string),
+		Profiles: make(map[string][]string),
+	}
+	for _, s := range sections {
+		var dest map[string][]string
+		switch s.name {
+		case "groups":
+			dest = cfg.Groups
+		case "profiles":
+			dest = cfg.Profiles
+		default:
+			return nil, &ParseError{filename, s.line, fmt.Sprintf("unknown section [%s]", s.name)}
+		}
+
+		for _, e := range s.entries {
+			items, err := parseList(e.value)
+			if err != nil {
+				return nil, &ParseError{filename, e.line, err.Error()}
+			}
+			dest[e.key] = items
+		}
+	}
+
+	for name := range cfg.Groups {
+		if _, err := cfg.Resolve([]string{GroupPrefix + name}); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+	return cfg, nil
+}
+
+// ConfigPath returns the path of the config file.
+func (h *Home) ConfigPath() string {
+	return filepath.Join(h.DataDir(), configFilename)
+}
+
+// LoadConfig will read the config file.  A missing config file results in
+// an empty config.
+func (h *Home) LoadConfig() (*Config, error) {
+	path := h.ConfigPath()
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &Config{
+			Groups:   map[string][]string{},
+			Profiles: map[string][]string{},
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config '%s': %v", path, err)
+	}
+	defer f.Close()
+
+	return ParseConfig(f, path)
+}
+
+// Resolve will expand any `@group` in names to the castles in the group.
+// Castles are returned in order with duplicates removed.
+func (c *Config) Resolve(names []string) ([]string, error) {
+	var resolved []string
+	seen := make(map[string]bool)
+	if err := c.resolve(names, seen, nil, &resolved); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+func (c *Config) resolve(names []string, seen map[string]bool, stack []string, resolved *[]string) error {
+	for _, name := range names {
+		if !strings.HasPrefix(name, GroupPrefix) {
+			if !seen[name] {
+				seen[name] = true
+				*resolved = append(*resolved, name)
+			}
+			continue
+		}
+
+		group := strings.TrimPrefix(name, GroupPrefix)
+		for _, g := range stack {
+			if g == group {
+				return fmt.Errorf("group cycle: %s", strings.Join(append(stack, group), " -> "))
+			}
+		}
+
+		members, ok := c.Groups[group]
+		if !ok {
+			return fmt.Errorf("unknown group '%s'", group)
+		}
+		if err := c.resolve(members, seen, append(stack, group), resolved); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Profile returns the castles for the given host.  The profile is looked up
+// by the full hostname, then the hostname up to the first dot and finally
+// `default`.  False is returned if there is no matching profile so it can be
+// told apart from a profile without any castles.
+func (c *Config) Profile(hostname string) ([]string, bool, error) {
+	keys := []string{hostname}
+	if i := strings.Index(hostname, "."); i > 0 {
+		keys = append(keys, hostname[:i])
+	}
+	keys = append(keys, "default")
+
+	for _, key := range keys {
+		if names, ok := c.Profiles[key]; ok {
+			resolved, err := c.Resolve(names)
+			return resolved, true, err
+		}
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,140 @@
+package homesick
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testConfig = `# castle groups
+[groups]
+work = [dotfiles, work-secrets, k8s-tools]
+servers = [dotfiles, "tmux"]
+all = [@work, @servers, private]
+
+[profiles]
+laptop = @all
+build.example.com = [@servers]
+default = dotfiles
+`
+
+func TestParseConfig(t *testing.T) {
+	tt := []struct {
+		name  string
+		input string
+		line  int
+	}{
+		{"valid", testConfig, 0},
+		{"empty", "", 0},
+		{"outside section", "work = [a]\n", 1},
+		{"unknown section", "[castles]\n", 1},
+		{"no value", "[groups]\nwork\n", 2},
+		{"unterminated list", "[groups]\nwork = [a, b\n", 2},
+		{"empty item", "[groups]\nwork = [a, , b]\n", 2},
+		{"duplicate", "[groups]\nwork = a\nwork = b\n", 3},
+		{"unterminated header", "[groups\n", 1},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseConfig(strings.NewReader(tc.input), configFilename)
+			if tc.line == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			perr, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("expected ParseError, got: %v", err)
+			}
+			if perr.Line != tc.line {
+				t.Errorf("wrong error line (want: %d, got: %d)", tc.line, perr.Line)
+			}
+		})
+	}
+}
+
+func TestConfigResolve(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(testConfig), configFilename)
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	tt := []struct {
+		names []string
+		want  []string
+		fail  bool
+	}{
+		{[]string{"dotfiles"}, []string{"dotfiles"}, false},
+		{[]string{"@work"}, []string{"dotfiles", "work-secrets", "k8s-tools"}, false},
+		{[]string{"@all"}, []string{"dotfiles", "work-secrets", "k8s-tools", "tmux", "private"}, false},
+		{[]string{"tmux", "@servers"}, []string{"tmux", "dotfiles"}, false},
+		{[]string{"@nope"}, nil, true},
+	}
+
+	for _, tc := range tt {
+		t.Run(strings.Join(tc.names, ","), func(t *testing.T) {
+			got, err := cfg.Resolve(tc.names)
+			if tc.fail {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cmp.Equal(tc.want, got) {
+				t.Errorf("wrong castles:\n%s", cmp.Diff(tc.want, got))
+			}
+		})
+	}
+
+	_, err = ParseConfig(strings.NewReader("[groups]\na = [@b]\nb = [@a]\n"), configFilename)
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected group cycle error, got: %v", err)
+	}
+}
+
+func TestConfigProfile(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(testConfig), configFilename)
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	tt := []struct {
+		host string
+		want []string
+	}{
+		{"laptop", []string{"dotfiles", "work-secrets", "k8s-tools", "tmux", "private"}},
+		{"laptop.example.com", []string{"dotfiles", "work-secrets", "k8s-tools", "tmux", "private"}},
+		{"build.example.com", []string{"dotfiles", "tmux"}},
+		{"other", []string{"dotfiles"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.host, func(t *testing.T) {
+			got, ok, err := cfg.Profile(tc.host)
+			if err != nil || !ok {
+				t.Fatalf("unexpected error: %v (found: %t)", err, ok)
+			}
+			if !cmp.Equal(tc.want, got) {
+				t.Errorf("wrong castles:\n%s", cmp.Diff(tc.want, got))
+			}
+		})
+	}
+
+	empty := &Config{}
+	if got, ok, _ := empty.Profile("laptop"); ok {
+		t.Errorf("expected no profile, got: %v", got)
+	}
+
+	// a profile without castles is still found
+	none := &Config{Profiles: map[string][]string{"laptop": {}}}
+	if got, ok, _ := none.Profile("laptop"); !ok || len(got) != 0 {
+		t.Errorf("expected an empty profile, got: %v (found: %t)", got, ok)
+	}
+}
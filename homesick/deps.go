@@ -0,0 +1,214 @@
+package homesick
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const depsFilename = ".homesick_deps"
+
+// Dependency is another castle a castle needs installed along with it.
+type Dependency struct {
+	// Name is the name the castle is cloned as.
+	Name string
+	// URI is where the castle is cloned from.
+	URI string
+	// Ref is an optional branch, tag or commit the castle must be at.
+	Ref string
+}
+
+func (d Dependency) String() string {
+	if d.Ref == "" {
+		return d.Name
+	}
+	return d.Name + "@" + d.Ref
+}
+
+// ParseDeps will read dependencies from r.  Every dependency is a section
+// named after the castle with a required `uri` and an optional `ref`.  The
+// uri is expanded like a cloned one so github `user/repo` shorthands work.
+//
+//	[base]
+//	uri = https://github.com/me/base.git
+//	ref = v1.2.0
+func ParseDeps(r io.Reader, filename string) ([]Dependency, error) {
+	sections, err := parseSections(r, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	deps := make([]Dependency, 0, len(sections))
+	seen := make(map[string]bool)
+	for _, s := range sections {
+		if seen[s.name] {
+			return nil, &ParseError{filename, s.line, fmt.Sprintf("duplicate dependency '%s'", s.name)}
+		}
+		seen[s.name] = true
+
+		if strings.ContainsAny(s.name, `/\`) || s.name == "." || s.name == ".." {
+			return nil, &ParseError{filename, s.line, fmt.Sprintf("invalid castle name '%s'", s.name)}
+		}
+
+		d := Dependency{Name: s.name}
+		for _, e := range s.entries {
+			switch e.key {
+			case "uri":
+				d.URI = ExpandURI(unquote(e.value))
+			case "ref":
+				d.Ref = unquote(e.value)
+			default:
+				return nil, &ParseError{filename, e.line, fmt.Sprintf("unknown key '%s'", e.key)}
+			}
+		}
+		if d.URI == "" {
+			return nil, &ParseError{filename, s.line, fmt.Sprintf("dependency '%s' has no uri", s.name)}
+		}
+		deps = append(deps, d)
+	}
+	return deps, nil
+}
+
+// Deps will read the .homesick_deps file from the castle.
+func (c Castle) Deps() ([]Dependency, error) {
+	depsFile := filepath.Join(c.Path, depsFilename)
+
+	f, err := os.Open(depsFile)
+	if os.IsNotExist(err) {
+		return []Dependency{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deps file '%s': %v", depsFile, err)
+	}
+	defer f.Close()
+
+	return ParseDeps(f, depsFile)
+}
+
+// depResolver walks the dependencies of castles keeping track of what has
+// been visited and what each castle was required as.
+type depResolver struct {
+	home  *Home
+	clone bool
+
+	required   map[string]Dependency
+	requiredBy map[string]string
+	done       map[string]bool
+	order      []*Castle
+}
+
+// DependencyOrder returns the castles in the order they must be linked:
+// every dependency comes before the castles that need it and each castle is
+// only returned once.  Missing dependencies are cloned (and checked out at
+// their ref) if clone is set, otherwise they are an error.  Cycles and castles
+// requiring the same dependency at different refs or from different URIs are
+// errors.  Cloned dependencies that aren't at their required ref are
+// reported but not changed.
+func (h *Home) DependencyOrder(castles []*Castle, clone bool) ([]*Castle, error) {
+	r := &depResolver{
+		home:       h,
+		clone:      clone,
+		required:   make(map[string]Dependency),
+		requiredBy: make(map[string]string),
+		done:       make(map[string]bool),
+	}
+	for _, c := range castles {
+		if r.done[c.Name] {
+			continue
+		}
+		if err := r.visit(c, nil); err != nil {
+			return nil, err
+		}
+	}
+	return r.order, nil
+}
+
+func (r *depResolver) visit(c *Castle, stack []string) error {
+	for _, name := range stack {
+		if name == c.Name {
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(stack, c.Name), " -> "))
+		}
+	}
+	stack = append(stack, c.Name)
+
+	deps, err := c.Deps()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range deps {
+		if prev, ok := r.required[d.Name]; ok {
+			if prev.Ref != d.Ref || prev.URI != d.URI {
+				return fmt.Errorf("castle '%s' requires %s but castle '%s' requires %s",
+					r.requiredBy[d.Name], describeDep(prev), c.Name, describeDep(d))
+			}
+		} else {
+			r.required[d.Name] = d
+			r.requiredBy[d.Name] = c.Name
+		}
+
+		dep, err := r.load(c, d)
+		if err != nil {
+			return err
+		}
+		if r.done[dep.Name] {
+			continue
+		}
+		if err := r.visit(dep, stack); err != nil {
+			return err
+		}
+	}
+
+	r.done[c.Name] = true
+	r.order = append(r.order, c)
+	return nil
+}
+
+// load returns the castle for a dependency cloning it if needed and allowed.
+func (r *depResolver) load(c *Castle, d Dependency) (*Castle, error) {
+	h := r.home
+
+	dep, err := h.Castle(d.Name)
+	if err == ErrCastleNotExist {
+		if !r.clone {
+			return nil, fmt.Errorf("castle '%s' depends on '%s' which isn't cloned", c.Name, d.Name)
+		}
+
+		dep, err = h.Clone(d.URI, d.Name)
+		if err != nil {
+			return nil, err
+		}
+		if d.Ref != "" {
+			h.statusf(StatusChange, "git checkout", "%s in castle '%s'", d.Ref, d.Name)
+			if err := h.git.Checkout(dep.Path, d.Ref); err != nil {
+				return nil, fmt.Errorf("failed to check out '%s' in castle '%s': %v", d.Ref, d.Name, err)
+			}
+		}
+		return dep, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load castle '%s': %v", d.Name, err)
+	}
+
+	if remote, err := dep.Remote(); err == nil && remote != "" && remote != d.URI {
+		h.statusf(StatusProblem, "mismatch", "castle '%s' is cloned from %s but castle '%s' requires %s", d.Name, remote, c.Name, d.URI)
+	}
+
+	if d.Ref != "" {
+		want, err := h.git.ResolveRef(dep.Path, d.Ref)
+		if err != nil {
+			return nil, fmt.Errorf("castle '%s' requires '%s' at %s: %v", c.Name, d.Name, d.Ref, err)
+		}
+		if head, _ := h.git.Head(dep.Path); head != want {
+			h.statusf(StatusProblem, "mismatch", "castle '%s' isn't at %s required by castle '%s'", d.Name, d.Ref, c.Name)
+		}
+	}
+	return dep, nil
+}
+
+// describeDep describes a requirement for error messages.
+func describeDep(d Dependency) string {
+	return fmt.Sprintf("'%s' from %s", d, d.URI)
+}
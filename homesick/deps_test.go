@@ -0,0 +1,132 @@
+package homesick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseDeps(t *testing.T) {
+	tt := []struct {
+		name  string
+		input string
+		want  []Dependency
+		line  int
+	}{
+		{"empty", "", []Dependency{}, 0},
+		{
+			"valid",
+			"[base]\nuri = https://example.com/base.git\nref = v1.0\n\n[tools]\nuri = \"me/tools\"\n",
+			[]Dependency{
+				{Name: "base", URI: "https://example.com/base.git", Ref: "v1.0"},
+				{Name: "tools", URI: "https://github.com/me/tools.git"},
+			},
+			0,
+		},
+		{"no uri", "[base]\nref = v1.0\n", nil, 1},
+		{"unknown key", "[base]\nurl = x\n", nil, 2},
+		{"duplicate", "[base]\nuri = a\n[base]\nuri = b\n", nil, 3},
+		{"bad name", "[../base]\nuri = a\n", nil, 1},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseDeps(strings.NewReader(tc.input), depsFilename)
+			if tc.line != 0 {
+				perr, ok := err.(*ParseError)
+				if !ok {
+					t.Fatalf("expected ParseError, got: %v", err)
+				}
+				if perr.Line != tc.line {
+					t.Errorf("wrong error line (want: %d, got: %d)", tc.line, perr.Line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cmp.Equal(tc.want, got) {
+				t.Errorf("wrong deps:\n%s", cmp.Diff(tc.want, got))
+			}
+		})
+	}
+}
+
+// makeCastle will create a minimal castle with the given deps file.
+func makeCastle(t *testing.T, h *Home, name, deps string) *Castle {
+	t.Helper()
+
+	path := h.CastlePath(name)
+	if err := os.MkdirAll(filepath.Join(path, ".git"), 0755); err != nil {
+		t.Fatalf("failed to create castle: %v", err)
+	}
+	if deps != "" {
+		if err := ioutil.WriteFile(filepath.Join(path, depsFilename), []byte(deps), 0644); err != nil {
+			t.Fatalf("failed to write deps: %v", err)
+		}
+	}
+
+	c, err := h.Castle(name)
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+	return c
+}
+
+func TestDependencyOrder(t *testing.T) {
+	h, cleanup := setupHomedir(t, "noRepos")
+	defer cleanup()
+
+	base := makeCastle(t, h, "base", "")
+	tools := makeCastle(t, h, "tools", "[base]\nuri = base.git\n")
+	team := makeCastle(t, h, "team", "[tools]\nuri = tools.git\n[base]\nuri = base.git\n")
+
+	names := func(castles []*Castle) []string {
+		var names []string
+		for _, c := range castles {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	got, err := h.DependencyOrder([]*Castle{team}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"base", "tools", "team"}; !cmp.Equal(want, names(got)) {
+		t.Errorf("wrong order:\n%s", cmp.Diff(want, names(got)))
+	}
+
+	got, err = h.DependencyOrder([]*Castle{base, team, tools}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"base", "tools", "team"}; !cmp.Equal(want, names(got)) {
+		t.Errorf("wrong order:\n%s", cmp.Diff(want, names(got)))
+	}
+
+	// missing dependencies aren't cloned unless asked
+	missing := makeCastle(t, h, "missing", "[nope]\nuri = nope.git\n")
+	if _, err := h.DependencyOrder([]*Castle{missing}, false); err == nil {
+		t.Error("expected error for missing dependency")
+	}
+
+	// the same dependency from different places
+	other := makeCastle(t, h, "other", "[base]\nuri = elsewhere.git\n")
+	if _, err := h.DependencyOrder([]*Castle{team, other}, false); err == nil || !strings.Contains(err.Error(), "requires") {
+		t.Errorf("expected mismatch error, got: %v", err)
+	}
+
+	// cycles
+	makeCastle(t, h, "a", "[b]\nuri = b.git\n")
+	makeCastle(t, h, "b", "[c]\nuri = c.git\n")
+	c := makeCastle(t, h, "c", "[a]\nuri = a.git\n")
+	_, err = h.DependencyOrder([]*Castle{c}, false)
+	if err == nil || !strings.Contains(err.Error(), "c -> a -> b -> c") {
+		t.Errorf("expected cycle error, got: %v", err)
+	}
+}
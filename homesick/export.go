@@ -0,0 +1,198 @@
+package homesick
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const exportFilename = "castles.json"
+
+// ExportedCastle describes a castle packed into a git bundle.
+type ExportedCastle struct {
+	Name   string `json:"name"`
+	Remote string `json:"remote,omitempty"`
+	Bundle string `json:"bundle"`
+	Head   string `json:"head"`
+}
+
+// exportMetadata is the file written next to the bundles.
+type exportMetadata struct {
+	Castles []ExportedCastle `json:"castles"`
+}
+
+// Export will pack each castle into a git bundle in dir along with a
+// castles.json recording their names, remotes and heads.  Dir is created if
+// needed.  Everything is written to a temporary directory next to dir first
+// so nothing in dir is touched if any castle fails to bundle.
+func (h *Home) Export(castles []*Castle, dir string) ([]ExportedCastle, error) {
+	dir = filepath.Clean(dir)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %v", err)
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), "."+filepath.Base(dir)+"-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	exported := make([]ExportedCastle, 0, len(castles))
+	files := make([]string, 0, len(castles)+1)
+	for _, c := range castles {
+		bundle := c.Name + ".bundle"
+		h.statusf(StatusChange, "git bundle", "castle '%s' to %s", c.Name, filepath.Join(dir, bundle))
+
+		file, err := filepath.Abs(filepath.Join(tmp, bundle))
+		if err != nil {
+			return nil, err
+		}
+		if err := h.git.CreateBundle(c.Path, file); err != nil {
+			return nil, fmt.Errorf("failed to bundle castle '%s': %v", c.Name, err)
+		}
+
+		remote, err := c.Remote()
+		if err != nil {
+			remote = ""
+		}
+		head, err := h.git.Head(c.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read head of castle '%s': %v", c.Name, err)
+		}
+
+		exported = append(exported, ExportedCastle{
+			Name:   c.Name,
+			Remote: remote,
+			Bundle: bundle,
+			Head:   head,
+		})
+		files = append(files, bundle)
+	}
+
+	data, err := json.MarshalIndent(exportMetadata{Castles: exported}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, exportFilename), append(data, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write export metadata: %v", err)
+	}
+	files = append(files, exportFilename)
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.Chmod(tmp, 0755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(tmp, dir); err != nil {
+			return nil, fmt.Errorf("failed to move export into place: %v", err)
+		}
+		return exported, nil
+	}
+
+	// an existing dir keeps its other files.  The metadata goes last so it
+	// never refers to bundles that weren't moved.
+	for _, f := range files {
+		if err := os.Rename(filepath.Join(tmp, f), filepath.Join(dir, f)); err != nil {
+			return nil, fmt.Errorf("failed to move export into place: %v", err)
+		}
+	}
+	return exported, nil
+}
+
+// ImportResult is the outcome of importing a single castle.
+type ImportResult struct {
+	Castle ExportedCastle
+	// Cloned is set if the castle didn't exist and was cloned from its
+	// bundle.
+	Cloned bool
+	// Updated is set if an existing castle was fast-forwarded.
+	Updated bool
+	Err     error
+}
+
+// Import will recreate the castles exported to dir.  Missing castles are
+// cloned from their bundle with their original remote restored.  Existing
+// castles are fast-forwarded to the bundle; castles that have diverged from
+// it are left alone and reported as failed.
+func (h *Home) Import(dir string) ([]*ImportResult, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, exportFilename))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export metadata: %v", err)
+	}
+	var meta exportMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse export metadata: %v", err)
+	}
+
+	results := make([]*ImportResult, 0, len(meta.Castles))
+	for _, e := range meta.Castles {
+		r := &ImportResult{Castle: e}
+		r.Cloned, r.Updated, r.Err = h.importCastle(dir, e)
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (h *Home) importCastle(dir string, e ExportedCastle) (cloned, updated bool, err error) {
+	if e.Name == "" || e.Name != filepath.Base(e.Name) || e.Name == ".." {
+		return false, false, fmt.Errorf("invalid castle name '%s'", e.Name)
+	}
+	if e.Bundle != filepath.Base(e.Bundle) {
+		return false, false, fmt.Errorf("invalid bundle name '%s'", e.Bundle)
+	}
+
+	bundle, err := filepath.Abs(filepath.Join(dir, e.Bundle))
+	if err != nil {
+		return false, false, err
+	}
+	if _, err := os.Stat(bundle); err != nil {
+		return false, false, fmt.Errorf("missing bundle: %v", err)
+	}
+
+	c, err := h.Castle(e.Name)
+	if err == ErrCastleNotExist {
+		c, err = h.Clone(bundle, e.Name)
+		if err != nil {
+			return false, false, err
+		}
+		if e.Remote != "" {
+			if err := h.git.RemoteSetURL(c.Path, "origin", e.Remote); err != nil {
+				return true, false, fmt.Errorf("failed to restore remote: %v", err)
+			}
+		}
+		return true, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to load castle: %v", err)
+	}
+
+	if remote, err := c.Remote(); err == nil && e.Remote != "" && remote != e.Remote {
+		h.statusf(StatusProblem, "mismatch", "castle '%s' is cloned from %s but was exported from %s", c.Name, remote, e.Remote)
+	}
+
+	before, err := h.git.Head(c.Path)
+	if err != nil {
+		return false, false, err
+	}
+
+	h.statusf(StatusChange, "git fetch", "castle '%s' from %s", c.Name, bundle)
+	if err := h.git.FetchBundle(c.Path, bundle); err != nil {
+		return false, false, fmt.Errorf("failed to fetch bundle: %v", err)
+	}
+	// the branch is fast-forwarded to the bundle's version of its upstream
+	upstream, err := h.git.Upstream(c.Path)
+	if err != nil || !strings.HasPrefix(upstream, "origin/") {
+		return false, false, fmt.Errorf("castle has no upstream on origin to fast-forward")
+	}
+	ref := importRefPrefix + strings.TrimPrefix(upstream, "origin/")
+	if err := h.git.FastForward(c.Path, ref); err != nil {
+		return false, false, fmt.Errorf("failed to fast-forward: %v", err)
+	}
+
+	after, err := h.git.Head(c.Path)
+	if err != nil {
+		return false, false, err
+	}
+	return false, before != after, nil
+}
@@ -0,0 +1,203 @@
+package homesick
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// DefaultTemplate is the template used to scaffold a new castle when none is
+// given.
+const DefaultTemplate = "default"
+
+// templateSuffix marks template files that are rendered with text/template.
+// The suffix is removed from the generated file.
+const templateSuffix = ".tmpl"
+
+// TemplateData is what template files are rendered with.
+type TemplateData struct {
+	// Name of the castle being generated.
+	Name string
+}
+
+// builtinTemplates are the templates that are always available.  A template
+// directory with the same name overrides them.
+var builtinTemplates = map[string]map[string]string{
+	"default": {
+		"README.md.tmpl": `# {{.Name}}
+
+Dotfiles managed by [heartsick](https://github.com/nemith/heartsick).
+
+Everything in ` + "`home/`" + ` is linked into the home directory:
+
+    heartsick clone URI {{.Name}}
+    heartsick link {{.Name}}
+`,
+		subdirFilename: `# Directories whose contents are linked one by one instead of linking the
+# directory itself (i.e. .config).  One per line, glob patterns are allowed.
+`,
+		ignoreFilename: `# Paths in home/ that are never linked (i.e. .DS_Store).  One per line, glob
+# patterns are allowed.  Only paths that are linked on their own can be
+# ignored: the top level of home/, the contents of the directories in
+# .homesick_subdir and everything in deep castles.  A pattern like
+# .config/*/cache needs .config/* in .homesick_subdir.
+`,
+		rcFilename: `#!/bin/sh
+# Run from the root of the castle by ` + "`heartsick rc`" + ` with HEARTSICK_CASTLE,
+# HEARTSICK_CASTLE_PATH and HEARTSICK_HOME set.  Use it for setup that links
+# can't do like installing plugins.
+set -e
+`,
+		".gitignore": `*.swp
+*~
+.DS_Store
+`,
+	},
+	"empty": {},
+}
+
+// TemplatesDir returns the directory holding user templates, one directory
+// per template.
+func (h *Home) TemplatesDir() string {
+	return filepath.Join(h.DataDir(), "templates")
+}
+
+// Templates returns the names of the builtin and user templates.
+func (h *Home) Templates() ([]string, error) {
+	seen := make(map[string]bool)
+	for name := range builtinTemplates {
+		seen[name] = true
+	}
+
+	files, err := ioutil.ReadDir(h.TemplatesDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read templates: %v", err)
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			seen[f.Name()] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// templateFile is a single file of a template.
+type templateFile struct {
+	content []byte
+	mode    os.FileMode
+}
+
+// loadTemplate returns the files of a template by their relative path.  User
+// templates are read from TemplatesDir before falling back to the builtin
+// ones.
+func (h *Home) loadTemplate(name string) (map[string]templateFile, error) {
+	if name == "" || name != filepath.Base(name) || name == ".." {
+		return nil, fmt.Errorf("invalid template name '%s'", name)
+	}
+
+	dir := filepath.Join(h.TemplatesDir(), name)
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		files := make(map[string]templateFile)
+		err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files[rel] = templateFile{content: content, mode: fi.Mode().Perm()}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template '%s': %v", name, err)
+		}
+		return files, nil
+	}
+
+	builtin, ok := builtinTemplates[name]
+	if !ok {
+		names, _ := h.Templates()
+		return nil, fmt.Errorf("unknown template '%s' (available: %s)", name, strings.Join(names, ", "))
+	}
+	files := make(map[string]templateFile, len(builtin))
+	for rel, content := range builtin {
+		files[filepath.FromSlash(rel)] = templateFile{content: []byte(content), mode: 0644}
+	}
+	return files, nil
+}
+
+// Scaffold will write the files of a template into the castle at path.  Files
+// ending in .tmpl are rendered with TemplateData and have the suffix removed.
+// Existing files are left alone.  Returns the files that were created relative
+// to path.
+func (h *Home) Scaffold(path, name string) ([]string, error) {
+	files, err := h.loadTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	data := TemplateData{Name: filepath.Base(abs)}
+
+	rels := make([]string, 0, len(files))
+	for rel := range files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	var created []string
+	for _, rel := range rels {
+		f := files[rel]
+		content := f.content
+		if strings.HasSuffix(rel, templateSuffix) {
+			t, err := template.New(rel).Parse(string(content))
+			if err != nil {
+				return created, fmt.Errorf("failed to parse template file '%s': %v", rel, err)
+			}
+			var buf bytes.Buffer
+			if err := t.Execute(&buf, data); err != nil {
+				return created, fmt.Errorf("failed to render template file '%s': %v", rel, err)
+			}
+			content = buf.Bytes()
+			rel = strings.TrimSuffix(rel, templateSuffix)
+		}
+
+		dest := filepath.Join(path, rel)
+		if _, err := os.Lstat(dest); err == nil {
+			h.statusf(StatusInfo, "exist", "%s", dest)
+			continue
+		}
+
+		h.statusf(StatusChange, "create", "%s", dest)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return created, fmt.Errorf("failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(dest, content, f.mode); err != nil {
+			return created, fmt.Errorf("failed to write '%s': %v", dest, err)
+		}
+		created = append(created, rel)
+	}
+	return created, nil
+}
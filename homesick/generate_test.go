@@ -0,0 +1,96 @@
+package homesick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestScaffold(t *testing.T) {
+	h, cleanup := setupHomedir(t, "emptyHome")
+	defer cleanup()
+
+	path := filepath.Join(h.Dir(), "castles", "mine")
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatalf("failed to create castle dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, ".gitignore"), []byte("keep\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	created, err := h.Scaffold(path, DefaultTemplate)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{ignoreFilename, subdirFilename, rcFilename, "README.md"}
+	if !cmp.Equal(want, created) {
+		t.Errorf("wrong files created:\n%s", cmp.Diff(want, created))
+	}
+
+	readme, _ := ioutil.ReadFile(filepath.Join(path, "README.md"))
+	if !strings.HasPrefix(string(readme), "# mine\n") {
+		t.Errorf("README wasn't rendered:\n%s", readme)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(path, ".gitignore")); string(data) != "keep\n" {
+		t.Errorf("existing file was overwritten: %q", data)
+	}
+
+	// the scaffolded configuration files must parse
+	c := Castle{Name: "mine", Path: path, home: h}
+	if _, err := c.Subdirs(); err != nil {
+		t.Errorf("scaffolded subdir file is invalid: %v", err)
+	}
+	if _, err := c.Ignores(); err != nil {
+		t.Errorf("scaffolded ignore file is invalid: %v", err)
+	}
+}
+
+func TestScaffoldUserTemplate(t *testing.T) {
+	h, cleanup := setupHomedir(t, "emptyHome")
+	defer cleanup()
+
+	tmplDir := filepath.Join(h.TemplatesDir(), "work")
+	files := map[string]string{
+		"home/.workrc":     "export WORK=1\n",
+		"NOTES.md.tmpl":    "{{.Name}} notes\n",
+		"hooks/post-clone": "#!/bin/sh\n",
+	}
+	for rel, content := range files {
+		path := filepath.Join(tmplDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create template dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatalf("failed to write template file: %v", err)
+		}
+	}
+
+	names, err := h.Templates()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"default", "empty", "work"}; !cmp.Equal(want, names) {
+		t.Errorf("wrong templates:\n%s", cmp.Diff(want, names))
+	}
+
+	path := filepath.Join(h.Dir(), "work")
+	if _, err := h.Scaffold(path, "work"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(path, "NOTES.md")); string(data) != "work notes\n" {
+		t.Errorf("template wasn't rendered: %q", data)
+	}
+	if fi, err := os.Stat(filepath.Join(path, "hooks/post-clone")); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("hook wasn't copied with its mode: %v", err)
+	}
+
+	for _, name := range []string{"nope", "../work"} {
+		if _, err := h.Scaffold(path, name); err == nil {
+			t.Errorf("expected an error for template '%s'", name)
+		}
+	}
+}
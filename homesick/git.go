@@ -0,0 +1,446 @@
+package homesick
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GitBackend is every git operation heartsick needs.  All paths are the root
+// of a repository (usually a castle).
+type GitBackend interface {
+	// Clone will clone uri into dest along with any submodules.
+	Clone(uri, dest string) error
+	// Init will create a new repository.
+	Init(path string) error
+	// IsRepo returns true if path is the root of a repository.
+	IsRepo(path string) bool
+
+	// Config returns the value of a config option.
+	Config(path, opt string) (string, error)
+	// RemoteURL returns the url of the origin remote.
+	RemoteURL(path string) (string, error)
+	// RemoteExists returns true if the named remote is configured.
+	RemoteExists(path, name string) bool
+	// RemoteAdd will add a new remote.
+	RemoteAdd(path, name, url string) error
+	// RemoteSetURL will change the url of an existing remote.
+	RemoteSetURL(path, name, url string) error
+
+	// Fetch will fetch from the default remote.
+	Fetch(path string) error
+	// Pull will fetch and merge from the upstream branch.
+	Pull(path string) error
+	// Push will push to the upstream branch writing any output to w (nil
+	// for none).  Credentials are only prompted for if interactive is set,
+	// otherwise ErrNeedsCredentials is returned when they are needed.
+	Push(path string, w io.Writer, interactive bool) error
+	// Upstream returns the upstream of the current branch or an empty string
+	// if there is none.
+	Upstream(path string) (string, error)
+	// AheadBehind returns how many commits HEAD is ahead and behind its
+	// upstream.
+	AheadBehind(path string) (ahead, behind int, err error)
+	// FastForward will fast-forward the current branch to ref.
+	FastForward(path, ref string) error
+
+	// CreateBundle will write every branch and tag to a bundle file.
+	CreateBundle(path, file string) error
+	// FetchBundle will fetch the branches in a bundle file into
+	// refs/heartsick-import/ replacing any fetched before.  Remote branches
+	// are left alone.
+	FetchBundle(path, file string) error
+
+	// CommitAll will commit all changes to tracked files.
+	CommitAll(path, msg string, edit bool) error
+	// AddUntracked will stage untracked files under dir.
+	AddUntracked(path, dir string) ([]string, error)
+	// Head returns the commit id of HEAD.
+	Head(path string) (string, error)
+	// ResolveRef returns the commit id of a branch, tag or commit.  Branches
+	// that only exist on the origin remote are resolved too.
+	ResolveRef(path, ref string) (string, error)
+	// Checkout will check out a branch, tag or commit.
+	Checkout(path, ref string) error
+	// ChangedFiles returns the files changed between two commits.
+	ChangedFiles(path, from, to string) ([]string, error)
+	// FileVersions returns the contents of file (relative to path) in at
+	// most max of the latest commits on the current branch that changed
+	// it, newest first.
+	FileVersions(path, file string, max int) ([][]byte, error)
+	// StatusFiles returns the files changed in the work tree.
+	StatusFiles(path string, untracked bool) ([]string, error)
+	// ConflictedFiles returns the files with unresolved merge conflicts.
+	ConflictedFiles(path string) ([]string, error)
+
+	// Diff will write the diff of uncommitted changes to w.
+	Diff(path string, w io.Writer) error
+	// Status will write the status of the work tree to w.
+	Status(path string, w io.Writer) error
+}
+
+// ErrNeedsCredentials is returned by a push that isn't interactive when
+// git would have prompted for credentials.
+var ErrNeedsCredentials = errors.New("credentials are needed")
+
+// ExecGit implements GitBackend by running the git binary.  The streams are
+// used by the commands that can prompt or show progress; nil streams are
+// connected to the null device.
+type ExecGit struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// cmdErr will return and error with the output from stderr from the error if
+// it exists.
+func cmdErr(err error) error {
+	if eerr, ok := err.(*exec.ExitError); ok {
+		stderr := strings.TrimSpace(string(eerr.Stderr))
+		if stderr != "" {
+			return errors.New(stderr)
+		}
+	}
+	return err
+}
+
+// splitLines splits command output into non-empty lines.
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (ExecGit) RemoteURL(path string) (string, error) {
+	cmd := exec.Command("git", "config", "remote.origin.url")
+	cmd.Dir = path
+
+	output, err := cmd.Output()
+
+	return strings.TrimSpace(string(output)), cmdErr(err)
+}
+
+func (e ExecGit) Clone(uri, dest string) error {
+	cmd := exec.Command("git", "clone",
+		"-q", "--config", "push.default=upstream", "--recursive",
+		uri, dest)
+	cmd.Stdin = e.Stdin
+	cmd.Stdout = e.Stdout
+	return cmdErr(cmd.Run())
+}
+
+// var errGitAlreadyInitalized = errors.New("git already initialized")
+
+func (ExecGit) Init(path string) error {
+	cmd := exec.Command("git", "init")
+	cmd.Dir = path
+	return cmdErr(cmd.Run())
+}
+
+func (e ExecGit) RemoteExists(path, name string) bool {
+	existingRemote, _ := e.Config(path, "remote."+name+".url")
+	return existingRemote != ""
+}
+
+func (ExecGit) RemoteAdd(path, name, url string) error {
+	cmd := exec.Command("git", "remote", "add", name, url)
+	cmd.Dir = path
+	return cmdErr(cmd.Run())
+}
+
+func (ExecGit) RemoteSetURL(path, name, url string) error {
+	cmd := exec.Command("git", "remote", "set-url", name, url)
+	cmd.Dir = path
+	_, err := cmd.Output()
+	return cmdErr(err)
+}
+
+func (ExecGit) Config(path, opt string) (string, error) {
+	cmd := exec.Command("git", "config", opt)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), cmdErr(err)
+}
+
+func (ExecGit) IsRepo(path string) bool {
+	if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+		return true
+	}
+	return false
+}
+
+// CommitAll will commit all changes to tracked files.  If edit is set or
+// no message is given the editor is opened.
+func (e ExecGit) CommitAll(path, msg string, edit bool) error {
+	args := []string{"commit", "-a"}
+	if msg != "" {
+		args = append(args, "-m", msg)
+	}
+	if edit {
+		args = append(args, "-e")
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Stdin = e.Stdin
+	cmd.Stdout = e.Stdout
+	cmd.Stderr = e.Stderr
+	cmd.Dir = path
+	return cmdErr(cmd.Run())
+
+}
+
+// AddUntracked will stage all untracked files under dir that aren't
+// ignored.  Returns the files that were added.
+func (ExecGit) AddUntracked(path, dir string) ([]string, error) {
+	cmd := exec.Command("git", "ls-files", "--others", "--exclude-standard", "--", dir)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, cmdErr(err)
+	}
+
+	files := splitLines(string(output))
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	cmd = exec.Command("git", append([]string{"add", "--"}, files...)...)
+	cmd.Dir = path
+	if _, err := cmd.Output(); err != nil {
+		return nil, cmdErr(err)
+	}
+	return files, nil
+}
+
+// ConflictedFiles returns any files with unresolved merge conflicts.
+func (ExecGit) ConflictedFiles(path string) ([]string, error) {
+	cmd := exec.Command("git", "diff", "--name-only", "--diff-filter=U")
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, cmdErr(err)
+	}
+	return splitLines(string(output)), nil
+}
+
+func (ExecGit) Diff(path string, w io.Writer) error {
+	cmd := exec.Command("git", "diff")
+	cmd.Stdout = w
+	cmd.Dir = path
+	return cmdErr(cmd.Run())
+}
+
+func (ExecGit) Pull(path string) error {
+	cmd := exec.Command("git", "pull")
+	cmd.Dir = path
+	return cmdErr(cmd.Run())
+}
+
+// Push will push to the upstream branch.  Without interactive git is told
+// not to prompt for credentials so it fails instead of waiting on a
+// terminal nobody is reading.  Passphrases for ssh keys can't be turned off
+// this way so keys need to be in an agent.
+func (e ExecGit) Push(path string, w io.Writer, interactive bool) error {
+	args := []string{"push"}
+	if w == nil {
+		args = append(args, "-q")
+	}
+	cmd := exec.Command("git", args...)
+	if interactive {
+		cmd.Stdin = e.Stdin
+	} else {
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	}
+	cmd.Dir = path
+
+	var err error
+	if w == nil {
+		_, err = cmd.Output()
+	} else {
+		cmd.Stdout = w
+		err = cmd.Run()
+	}
+	err = cmdErr(err)
+	if err != nil && !interactive && strings.Contains(err.Error(), "terminal prompts disabled") {
+		return fmt.Errorf("%w: %v", ErrNeedsCredentials, err)
+	}
+	return err
+}
+
+func (ExecGit) Status(path string, w io.Writer) error {
+	cmd := exec.Command("git", "status")
+	cmd.Stdout = w
+	cmd.Dir = path
+	return cmdErr(cmd.Run())
+
+}
+
+func (ExecGit) Head(path string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = path
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), cmdErr(err)
+}
+
+// ResolveRef returns the commit id of ref falling back to the branch of the
+// same name on origin.
+func (ExecGit) ResolveRef(path, ref string) (string, error) {
+	var err error
+	for _, r := range []string{ref, "origin/" + ref} {
+		cmd := exec.Command("git", "rev-parse", "--verify", "-q", r+"^{commit}")
+		cmd.Dir = path
+		var output []byte
+		output, err = cmd.Output()
+		if err == nil {
+			return strings.TrimSpace(string(output)), nil
+		}
+	}
+	if _, ok := err.(*exec.ExitError); ok {
+		return "", fmt.Errorf("unknown ref '%s'", ref)
+	}
+	return "", cmdErr(err)
+}
+
+func (ExecGit) Checkout(path, ref string) error {
+	cmd := exec.Command("git", "checkout", "-q", ref)
+	cmd.Dir = path
+	_, err := cmd.Output()
+	return cmdErr(err)
+}
+
+// ChangedFiles returns the files changed between two commits.
+func (ExecGit) ChangedFiles(path, from, to string) ([]string, error) {
+	cmd := exec.Command("git", "diff", "--name-only", from, to)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, cmdErr(err)
+	}
+	return splitLines(string(output)), nil
+}
+
+// FileVersions returns the contents of file in the latest commits that
+// changed it.  Commits that deleted it are skipped.
+func (ExecGit) FileVersions(path, file string, max int) ([][]byte, error) {
+	cmd := exec.Command("git", "rev-list", "-n", strconv.Itoa(max), "HEAD", "--", file)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, cmdErr(err)
+	}
+
+	var versions [][]byte
+	for _, commit := range splitLines(string(output)) {
+		cmd := exec.Command("git", "show", commit+":"+filepath.ToSlash(file))
+		cmd.Dir = path
+		content, err := cmd.Output()
+		if err != nil {
+			continue
+		}
+		versions = append(versions, content)
+	}
+	return versions, nil
+}
+
+// StatusFiles returns the paths of all modified, added or deleted files in
+// the work tree, including untracked files if requested.
+func (ExecGit) StatusFiles(path string, untracked bool) ([]string, error) {
+	untrackedOpt := "--untracked-files=no"
+	if untracked {
+		untrackedOpt = "--untracked-files=all"
+	}
+	cmd := exec.Command("git", "status", "--porcelain", "-z", untrackedOpt)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, cmdErr(err)
+	}
+
+	var files []string
+	entries := strings.Split(string(output), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		files = append(files, entry[3:])
+		// renames and copies are followed by the original path
+		if strings.ContainsAny(entry[:2], "RC") {
+			i++
+		}
+	}
+	return files, nil
+}
+
+func (ExecGit) Fetch(path string) error {
+	cmd := exec.Command("git", "fetch", "-q")
+	cmd.Dir = path
+	_, err := cmd.Output()
+	return cmdErr(err)
+}
+
+// Upstream returns the upstream branch of the current branch.  An empty
+// string is returned if there is no upstream.
+func (ExecGit) Upstream(path string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{u}")
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if _, ok := err.(*exec.ExitError); ok {
+		return "", nil
+	}
+	return strings.TrimSpace(string(output)), cmdErr(err)
+}
+
+// AheadBehind returns how many commits HEAD is ahead and behind its
+// upstream.
+func (ExecGit) AheadBehind(path string) (ahead, behind int, err error) {
+	cmd := exec.Command("git", "rev-list", "--left-right", "--count", "HEAD...@{u}")
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, 0, cmdErr(err)
+	}
+
+	if _, err := fmt.Sscan(string(output), &ahead, &behind); err != nil {
+		return 0, 0, fmt.Errorf("unexpected rev-list output '%s': %v", strings.TrimSpace(string(output)), err)
+	}
+	return ahead, behind, nil
+}
+
+// importRefPrefix is where FetchBundle puts the branches of a bundle so
+// importing never rewrites the origin remote branches.
+const importRefPrefix = "refs/heartsick-import/"
+
+// FastForward will merge ref into the current branch only if it doesn't need
+// a merge commit.
+func (ExecGit) FastForward(path, ref string) error {
+	cmd := exec.Command("git", "merge", "-q", "--ff-only", ref)
+	cmd.Dir = path
+	_, err := cmd.Output()
+	return cmdErr(err)
+}
+
+// CreateBundle will write every ref to a bundle file.
+func (ExecGit) CreateBundle(path, file string) error {
+	cmd := exec.Command("git", "bundle", "create", "-q", file, "--all")
+	cmd.Dir = path
+	_, err := cmd.Output()
+	return cmdErr(err)
+}
+
+// FetchBundle will fetch the branches in a bundle into refs/heartsick-import/
+// pruning branches that are no longer in the bundle.
+func (ExecGit) FetchBundle(path, file string) error {
+	cmd := exec.Command("git", "fetch", "-q", "--prune", file, "+refs/heads/*:"+importRefPrefix+"*")
+	cmd.Dir = path
+	_, err := cmd.Output()
+	return cmdErr(err)
+}
@@ -0,0 +1,994 @@
+package homesick
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// GoGit implements GitBackend in pure Go so heartsick works where the git
+// binary isn't installed.  It has a few limits compared to ExecGit: pulls
+// only fast-forward, commit messages can't be edited, credentials are never
+// prompted for (ssh uses the agent) and submodules are only cloned.  Local
+// repositories still need git-upload-pack and git-receive-pack unless the
+// program calls InstallFileTransport.
+type GoGit struct{}
+
+// errNeedsGitBinary is returned for things only the git binary can do.
+var errNeedsGitBinary = errors.New("not supported without the git binary")
+
+// InstallFileTransport will serve local repositories in process instead of
+// with git-upload-pack and git-receive-pack so GoGit can clone, fetch and push
+// them without the git binary.  It replaces the file transport of go-git for
+// the whole process so it's left to the program to call, usually from main.
+func InstallFileTransport() {
+	client.InstallProtocol("file", server.NewServer(repoLoader{}))
+}
+
+func (GoGit) open(path string) (*git.Repository, error) {
+	return git.PlainOpen(path)
+}
+
+// repoLoader loads bare and non-bare repositories for the in process file
+// transport.
+type repoLoader struct{}
+
+func (repoLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	r, err := git.PlainOpen(ep.Path)
+	if err != nil {
+		return nil, transport.ErrRepositoryNotFound
+	}
+	return r.Storer, nil
+}
+
+func (g GoGit) Clone(uri, dest string) error {
+	if isBundle(uri) {
+		if err := g.cloneBundle(uri, dest); err != nil {
+			os.RemoveAll(dest)
+			return err
+		}
+		return nil
+	}
+
+	r, err := git.PlainClone(dest, false, &git.CloneOptions{
+		URL:               uri,
+		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+	})
+	if err != nil {
+		return err
+	}
+	return setPushDefault(r)
+}
+
+// setPushDefault sets push.default=upstream like ExecGit does when cloning
+// so the castle behaves the same if the git binary is used later.
+func setPushDefault(r *git.Repository) error {
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Raw.Section("push").SetOption("default", "upstream")
+	return r.SetConfig(cfg)
+}
+
+func (GoGit) Init(path string) error {
+	_, err := git.PlainInit(path, false)
+	return err
+}
+
+func (GoGit) IsRepo(path string) bool {
+	if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+		return true
+	}
+	return false
+}
+
+// Config returns the value of opt from the repository, global or system
+// config in that order.  Path doesn't have to be a repository.
+func (g GoGit) Config(path, opt string) (string, error) {
+	parts := strings.Split(opt, ".")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("invalid config option '%s'", opt)
+	}
+	section, key := parts[0], parts[len(parts)-1]
+	sub := strings.Join(parts[1:len(parts)-1], ".")
+
+	var cfgs []*config.Config
+	if r, err := g.open(path); err == nil {
+		if cfg, err := r.Config(); err == nil {
+			cfgs = append(cfgs, cfg)
+		}
+	}
+	for _, scope := range []config.Scope{config.GlobalScope, config.SystemScope} {
+		if cfg, err := config.LoadConfig(scope); err == nil {
+			cfgs = append(cfgs, cfg)
+		}
+	}
+
+	for _, cfg := range cfgs {
+		s := cfg.Raw.Section(section)
+		var value string
+		if sub != "" {
+			value = s.Subsection(sub).Option(key)
+		} else {
+			value = s.Option(key)
+		}
+		if value != "" {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("config option '%s' not set", opt)
+}
+
+func (g GoGit) RemoteURL(path string) (string, error) {
+	r, err := g.open(path)
+	if err != nil {
+		return "", err
+	}
+	remote, err := r.Remote("origin")
+	if err != nil {
+		return "", err
+	}
+	if urls := remote.Config().URLs; len(urls) > 0 {
+		return urls[0], nil
+	}
+	return "", nil
+}
+
+func (g GoGit) RemoteExists(path, name string) bool {
+	r, err := g.open(path)
+	if err != nil {
+		return false
+	}
+	_, err = r.Remote(name)
+	return err == nil
+}
+
+func (g GoGit) RemoteAdd(path, name, url string) error {
+	r, err := g.open(path)
+	if err != nil {
+		return err
+	}
+	_, err = r.CreateRemote(&config.RemoteConfig{Name: name, URLs: []string{url}})
+	return err
+}
+
+func (g GoGit) RemoteSetURL(path, name, url string) error {
+	r, err := g.open(path)
+	if err != nil {
+		return err
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	remote, ok := cfg.Remotes[name]
+	if !ok {
+		return fmt.Errorf("no such remote '%s'", name)
+	}
+	remote.URLs = []string{url}
+	return r.SetConfig(cfg)
+}
+
+func (g GoGit) Fetch(path string) error {
+	r, err := g.open(path)
+	if err != nil {
+		return err
+	}
+	err = r.Fetch(&git.FetchOptions{RemoteName: "origin"})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+// Pull will fetch and fast-forward to the upstream branch.  Branches that
+// have diverged can't be merged.
+func (g GoGit) Pull(path string) error {
+	if err := g.Fetch(path); err != nil {
+		return err
+	}
+	r, err := g.open(path)
+	if err != nil {
+		return err
+	}
+	up, err := upstreamRef(r)
+	if err != nil {
+		return err
+	}
+	if up == nil {
+		return errors.New("no upstream configured")
+	}
+	return fastForward(r, up.Hash())
+}
+
+// Push will push the current branch to its upstream.  Credentials are never
+// prompted for so interactive is ignored.
+func (g GoGit) Push(path string, w io.Writer, interactive bool) error {
+	r, err := g.open(path)
+	if err != nil {
+		return err
+	}
+	head, b, err := upstreamBranch(r)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return errors.New("the current branch has no upstream branch")
+	}
+
+	err = r.Push(&git.PushOptions{
+		RemoteName: b.Remote,
+		RefSpecs:   []config.RefSpec{config.RefSpec(head.String() + ":" + b.Merge.String())},
+		Progress:   w,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+// upstreamBranch returns the branch HEAD is on along with its config.  The
+// config is nil if HEAD is detached or the branch has no upstream.
+func upstreamBranch(r *git.Repository) (plumbing.ReferenceName, *config.Branch, error) {
+	head, err := r.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return "", nil, err
+	}
+	if head.Type() != plumbing.SymbolicReference {
+		return "", nil, nil
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return "", nil, err
+	}
+	b, ok := cfg.Branches[head.Target().Short()]
+	if !ok || b.Remote == "" || b.Merge == "" {
+		return head.Target(), nil, nil
+	}
+	return head.Target(), b, nil
+}
+
+// upstreamRef returns the remote tracking branch of the upstream or nil if
+// there is none.
+func upstreamRef(r *git.Repository) (*plumbing.Reference, error) {
+	_, b, err := upstreamBranch(r)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	name := b.Merge
+	if b.Remote != "." {
+		name = plumbing.NewRemoteReferenceName(b.Remote, b.Merge.Short())
+	}
+	ref, err := r.Reference(name, true)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	}
+	return ref, err
+}
+
+func (g GoGit) Upstream(path string) (string, error) {
+	r, err := g.open(path)
+	if err != nil {
+		return "", err
+	}
+	ref, err := upstreamRef(r)
+	if err != nil || ref == nil {
+		return "", err
+	}
+	return ref.Name().Short(), nil
+}
+
+func (g GoGit) AheadBehind(path string) (ahead, behind int, err error) {
+	r, err := g.open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return 0, 0, err
+	}
+	up, err := upstreamRef(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if up == nil {
+		return 0, 0, errors.New("no upstream configured")
+	}
+
+	ours, err := reachable(r, head.Hash())
+	if err != nil {
+		return 0, 0, err
+	}
+	theirs, err := reachable(r, up.Hash())
+	if err != nil {
+		return 0, 0, err
+	}
+	for h := range ours {
+		if !theirs[h] {
+			ahead++
+		}
+	}
+	for h := range theirs {
+		if !ours[h] {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// reachable returns every commit reachable from h.
+func reachable(r *git.Repository, h plumbing.Hash) (map[plumbing.Hash]bool, error) {
+	c, err := r.CommitObject(h)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[plumbing.Hash]bool)
+	err = object.NewCommitPreorderIter(c, nil, nil).ForEach(func(c *object.Commit) error {
+		seen[c.Hash] = true
+		return nil
+	})
+	return seen, err
+}
+
+// FastForward will move the current branch to ref if it doesn't need a
+// merge.  Local changes to tracked files stop it.
+func (g GoGit) FastForward(path, ref string) error {
+	r, err := g.open(path)
+	if err != nil {
+		return err
+	}
+	to, err := r.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s': %v", ref, err)
+	}
+	return fastForward(r, *to)
+}
+
+func fastForward(r *git.Repository, to plumbing.Hash) error {
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	if head.Hash() == to {
+		return nil
+	}
+
+	from, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	target, err := r.CommitObject(to)
+	if err != nil {
+		return err
+	}
+	if ok, err := from.IsAncestor(target); err != nil {
+		return err
+	} else if !ok {
+		return errors.New("not possible to fast-forward, the branches have diverged")
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	if files, err := changedFiles(w, false); err != nil {
+		return err
+	} else if len(files) > 0 {
+		return fmt.Errorf("local changes to %s would be overwritten", strings.Join(files, ", "))
+	}
+	return w.Reset(&git.ResetOptions{Commit: to, Mode: git.MergeReset})
+}
+
+// CommitAll will commit all changes to tracked files.  The message can't be
+// edited without the git binary.
+func (g GoGit) CommitAll(path, msg string, edit bool) error {
+	if edit || msg == "" {
+		return fmt.Errorf("editing the commit message is %v", errNeedsGitBinary)
+	}
+	r, err := g.open(path)
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	if files, err := changedFiles(w, false); err != nil {
+		return err
+	} else if len(files) == 0 {
+		return errors.New("nothing to commit")
+	}
+	_, err = w.Commit(msg, &git.CommitOptions{All: true})
+	return err
+}
+
+// AddUntracked will stage all untracked files under dir that aren't
+// ignored.  Returns the files that were added.
+func (g GoGit) AddUntracked(path, dir string) ([]string, error) {
+	r, err := g.open(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	st, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.ToSlash(filepath.Clean(dir)) + "/"
+	var files []string
+	for file, s := range st {
+		if s.Worktree != git.Untracked {
+			continue
+		}
+		if prefix != "./" && !strings.HasPrefix(file, prefix) {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		if _, err := w.Add(file); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func (g GoGit) Head(path string) (string, error) {
+	r, err := g.open(path)
+	if err != nil {
+		return "", err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
+}
+
+// ResolveRef returns the commit id of ref falling back to the branch of the
+// same name on origin.
+func (g GoGit) ResolveRef(path, ref string) (string, error) {
+	r, err := g.open(path)
+	if err != nil {
+		return "", err
+	}
+	h, err := resolveRef(r, ref)
+	if err != nil {
+		return "", err
+	}
+	return h.String(), nil
+}
+
+func resolveRef(r *git.Repository, ref string) (plumbing.Hash, error) {
+	for _, rev := range []string{ref, "origin/" + ref} {
+		if h, err := r.ResolveRevision(plumbing.Revision(rev)); err == nil {
+			return *h, nil
+		}
+	}
+	return plumbing.ZeroHash, fmt.Errorf("unknown ref '%s'", ref)
+}
+
+// Checkout will check out a local branch or detach HEAD at any other ref.
+func (g GoGit) Checkout(path, ref string) error {
+	r, err := g.open(path)
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	branch := plumbing.NewBranchReferenceName(ref)
+	if _, err := r.Reference(branch, false); err == nil {
+		return w.Checkout(&git.CheckoutOptions{Branch: branch})
+	}
+	h, err := resolveRef(r, ref)
+	if err != nil {
+		return err
+	}
+	return w.Checkout(&git.CheckoutOptions{Hash: h})
+}
+
+// ChangedFiles returns the files changed between two commits.
+func (g GoGit) ChangedFiles(path, from, to string) ([]string, error) {
+	r, err := g.open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var trees [2]*object.Tree
+	for i, rev := range []string{from, to} {
+		h, err := r.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			return nil, fmt.Errorf("unknown ref '%s'", rev)
+		}
+		c, err := r.CommitObject(*h)
+		if err != nil {
+			return nil, err
+		}
+		if trees[i], err = c.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := object.DiffTree(trees[0], trees[1])
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, c := range changes {
+		name := c.To.Name
+		if name == "" {
+			name = c.From.Name
+		}
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// FileVersions returns the contents of file in the latest commits that
+// changed it.  Commits that deleted it are skipped.
+func (g GoGit) FileVersions(path, file string, max int) ([][]byte, error) {
+	r, err := g.open(path)
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.ToSlash(file)
+	iter, err := r.Log(&git.LogOptions{From: head.Hash(), FileName: &name})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var versions [][]byte
+	err = iter.ForEach(func(c *object.Commit) error {
+		if len(versions) >= max {
+			return storer.ErrStop
+		}
+		f, err := c.File(name)
+		if err != nil {
+			return nil
+		}
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		versions = append(versions, []byte(content))
+		return nil
+	})
+	return versions, err
+}
+
+func (g GoGit) StatusFiles(path string, untracked bool) ([]string, error) {
+	r, err := g.open(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	return changedFiles(w, untracked)
+}
+
+// changedFiles returns the sorted paths of all modified, added or deleted
+// files in the work tree including untracked files if requested.
+func changedFiles(w *git.Worktree, untracked bool) ([]string, error) {
+	st, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for file, s := range st {
+		if s.Worktree == git.Untracked && !untracked {
+			continue
+		}
+		if s.Staging == git.Unmodified && s.Worktree == git.Unmodified {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ConflictedFiles returns any files with unresolved merge conflicts.  GoGit
+// never merges so these can only come from the git binary.
+func (g GoGit) ConflictedFiles(path string) ([]string, error) {
+	r, err := g.open(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	st, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for file, s := range st {
+		if s.Staging == git.UpdatedButUnmerged || s.Worktree == git.UpdatedButUnmerged {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Diff will write a unified diff of the changes in the work tree that
+// aren't staged.
+func (g GoGit) Diff(path string, w io.Writer) error {
+	r, err := g.open(path)
+	if err != nil {
+		return err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	st, err := wt.Status()
+	if err != nil {
+		return err
+	}
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	for file, s := range st {
+		if s.Worktree != git.Unmodified && s.Worktree != git.Untracked {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		var staged, current []byte
+		if e, err := idx.Entry(file); err == nil {
+			if staged, err = blobContent(r, e.Hash); err != nil {
+				return err
+			}
+		}
+		current, err := ioutil.ReadFile(filepath.Join(path, filepath.FromSlash(file)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		fmt.Fprintf(w, "diff --git a/%s b/%s\n", file, file)
+		err = difflib.WriteUnifiedDiff(w, difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(staged)),
+			B:        difflib.SplitLines(string(current)),
+			FromFile: "a/" + file,
+			ToFile:   "b/" + file,
+			Context:  3,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func blobContent(r *git.Repository, h plumbing.Hash) ([]byte, error) {
+	b, err := r.BlobObject(h)
+	if err != nil {
+		return nil, err
+	}
+	rd, err := b.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return ioutil.ReadAll(rd)
+}
+
+// Status will write the changed files in the short format of git status.
+func (g GoGit) Status(path string, w io.Writer) error {
+	r, err := g.open(path)
+	if err != nil {
+		return err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	st, err := wt.Status()
+	if err != nil {
+		return err
+	}
+
+	files, err := changedFiles(wt, true)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		_, err := fmt.Fprintln(w, "nothing to commit, working tree clean")
+		return err
+	}
+	for _, file := range files {
+		s := st[file]
+		if _, err := fmt.Fprintf(w, "%c%c %s\n", s.Staging, s.Worktree, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bundleHeader starts every v2 git bundle.
+const bundleHeader = "# v2 git bundle\n"
+
+// isBundle returns true if file is a git bundle.
+func isBundle(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	buf := make([]byte, len(bundleHeader))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return false
+	}
+	return string(buf) == bundleHeader
+}
+
+// CreateBundle will write HEAD and every branch and tag to a v2 bundle that
+// the git binary can read too.
+func (g GoGit) CreateBundle(path, file string) (err error) {
+	r, err := g.open(path)
+	if err != nil {
+		return err
+	}
+
+	var refs []*plumbing.Reference
+	if head, err := r.Head(); err == nil {
+		refs = append(refs, plumbing.NewHashReference(plumbing.HEAD, head.Hash()))
+	}
+	iter, err := r.References()
+	if err != nil {
+		return err
+	}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		n := ref.Name()
+		if ref.Type() == plumbing.HashReference && (n.IsBranch() || n.IsTag() || n.IsRemote()) {
+			refs = append(refs, ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return errors.New("refusing to create an empty bundle")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(bundleHeader)
+	tips := make([]plumbing.Hash, 0, len(refs))
+	for _, ref := range refs {
+		fmt.Fprintf(&buf, "%s %s\n", ref.Hash(), ref.Name())
+		tips = append(tips, ref.Hash())
+	}
+	buf.WriteString("\n")
+
+	objects, err := revlist.Objects(r.Storer, tips, nil)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(file)
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	if _, err := bw.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if _, err := packfile.NewEncoder(bw, r.Storer, false).Encode(objects, 10); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// readBundle will store the objects in a bundle in the repository and
+// return its refs.
+func readBundle(r *git.Repository, file string) ([]*plumbing.Reference, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var refs []*plumbing.Reference
+	for i := 0; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %v", err)
+		}
+		if i == 0 {
+			if line != bundleHeader {
+				return nil, errors.New("not a v2 git bundle")
+			}
+			continue
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "-") {
+			return nil, errors.New("bundles with prerequisite commits aren't supported")
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || !plumbing.IsHash(fields[0]) {
+			return nil, fmt.Errorf("invalid bundle ref '%s'", line)
+		}
+		refs = append(refs, plumbing.NewHashReference(plumbing.ReferenceName(fields[1]), plumbing.NewHash(fields[0])))
+	}
+
+	if err := packfile.UpdateObjectStorage(r.Storer, br); err != nil {
+		return nil, fmt.Errorf("failed to read bundle objects: %v", err)
+	}
+	return refs, nil
+}
+
+// FetchBundle will fetch the branches in a bundle into refs/heartsick-import/
+// replacing any fetched before.
+func (g GoGit) FetchBundle(path, file string) error {
+	r, err := g.open(path)
+	if err != nil {
+		return err
+	}
+	refs, err := readBundle(r, file)
+	if err != nil {
+		return err
+	}
+
+	old, err := r.References()
+	if err != nil {
+		return err
+	}
+	var stale []plumbing.ReferenceName
+	err = old.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().String(), importRefPrefix) {
+			stale = append(stale, ref.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range stale {
+		if err := r.Storer.RemoveReference(name); err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range refs {
+		if !ref.Name().IsBranch() {
+			continue
+		}
+		name := plumbing.ReferenceName(importRefPrefix + ref.Name().Short())
+		if err := r.Storer.SetReference(plumbing.NewHashReference(name, ref.Hash())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloneBundle will clone the branch HEAD points to in a bundle with the
+// bundle as the origin remote.
+func (g GoGit) cloneBundle(file, dest string) error {
+	r, err := git.PlainInit(dest, false)
+	if err != nil {
+		return err
+	}
+	if _, err := r.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{file}}); err != nil {
+		return err
+	}
+	refs, err := readBundle(r, file)
+	if err != nil {
+		return err
+	}
+
+	var (
+		head     plumbing.Hash
+		branches []*plumbing.Reference
+	)
+	for _, ref := range refs {
+		n := ref.Name()
+		switch {
+		case n == plumbing.HEAD:
+			head = ref.Hash()
+		case n.IsBranch():
+			branches = append(branches, ref)
+			remote := plumbing.NewRemoteReferenceName("origin", n.Short())
+			if err := r.Storer.SetReference(plumbing.NewHashReference(remote, ref.Hash())); err != nil {
+				return err
+			}
+		case n.IsTag():
+			if err := r.Storer.SetReference(ref); err != nil {
+				return err
+			}
+		}
+	}
+	if len(branches) == 0 {
+		return errors.New("bundle has no branches")
+	}
+
+	// check out the branch HEAD points to like git clone does
+	branch := branches[0]
+	for _, b := range branches {
+		if b.Hash() == head {
+			branch = b
+			break
+		}
+	}
+
+	if err := r.Storer.SetReference(branch); err != nil {
+		return err
+	}
+	if err := r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch.Name())); err != nil {
+		return err
+	}
+	err = r.CreateBranch(&config.Branch{
+		Name:   branch.Name().Short(),
+		Remote: "origin",
+		Merge:  branch.Name(),
+	})
+	if err != nil {
+		return err
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	if err := w.Reset(&git.ResetOptions{Commit: branch.Hash(), Mode: git.HardReset}); err != nil {
+		return err
+	}
+	return setPushDefault(r)
+}
@@ -0,0 +1,204 @@
+package homesick
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/google/go-cmp/cmp"
+)
+
+// setupGoGit returns a temporary directory along with a repository in its
+// `upstream` directory holding a single commit of home/.vimrc.
+func setupGoGit(t *testing.T) (string, string) {
+	t.Helper()
+	InstallFileTransport()
+
+	dir, err := ioutil.TempDir("", "gogit")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	upstream := filepath.Join(dir, "upstream")
+	var g GoGit
+	if err := g.Init(upstream); err != nil {
+		t.Fatalf("failed to init: %v", err)
+	}
+	setGoGitUser(t, upstream)
+	writeGoGitFile(t, upstream, "home/.vimrc", "set nocompatible\n")
+
+	added, err := g.AddUntracked(upstream, "home")
+	if err != nil {
+		t.Fatalf("failed to add untracked files: %v", err)
+	}
+	if want := []string{"home/.vimrc"}; !cmp.Equal(want, added) {
+		t.Fatalf("wrong files added:\n%s", cmp.Diff(want, added))
+	}
+	if err := g.CommitAll(upstream, "initial", false); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	return dir, upstream
+}
+
+func setGoGitUser(t *testing.T, path string) {
+	t.Helper()
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		t.Fatalf("failed to open repo: %v", err)
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	cfg.User.Name = "Test"
+	cfg.User.Email = "test@example.com"
+	if err := r.SetConfig(cfg); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+}
+
+func writeGoGitFile(t *testing.T, repo, file, content string) {
+	t.Helper()
+	path := filepath.Join(repo, file)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+}
+
+func TestGoGitCloneCommitPush(t *testing.T) {
+	dir, upstream := setupGoGit(t)
+	var g GoGit
+
+	clone := filepath.Join(dir, "clone")
+	if err := g.Clone(upstream, clone); err != nil {
+		t.Fatalf("failed to clone: %v", err)
+	}
+	setGoGitUser(t, clone)
+
+	if url, err := g.RemoteURL(clone); err != nil || url != upstream {
+		t.Errorf("wrong remote (got: %q, err: %v)", url, err)
+	}
+	if up, err := g.Upstream(clone); err != nil || up != "origin/master" {
+		t.Errorf("wrong upstream (got: %q, err: %v)", up, err)
+	}
+	if v, err := g.Config(clone, "push.default"); err != nil || v != "upstream" {
+		t.Errorf("wrong push.default (got: %q, err: %v)", v, err)
+	}
+
+	writeGoGitFile(t, clone, "home/.vimrc", "set nocompatible\nsyntax on\n")
+	files, err := g.StatusFiles(clone, false)
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if want := []string{"home/.vimrc"}; !cmp.Equal(want, files) {
+		t.Errorf("wrong status files:\n%s", cmp.Diff(want, files))
+	}
+
+	var diff bytes.Buffer
+	if err := g.Diff(clone, &diff); err != nil {
+		t.Fatalf("failed to diff: %v", err)
+	}
+	if !strings.Contains(diff.String(), "+syntax on\n") {
+		t.Errorf("diff is missing the change:\n%s", diff.String())
+	}
+
+	before, _ := g.Head(clone)
+	if err := g.CommitAll(clone, "syntax", false); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := g.CommitAll(clone, "again", false); err == nil {
+		t.Error("expected committing a clean work tree to fail")
+	}
+	after, _ := g.Head(clone)
+
+	changed, err := g.ChangedFiles(clone, before, after)
+	if err != nil {
+		t.Fatalf("failed to get changed files: %v", err)
+	}
+	if want := []string{"home/.vimrc"}; !cmp.Equal(want, changed) {
+		t.Errorf("wrong changed files:\n%s", cmp.Diff(want, changed))
+	}
+
+	if ahead, behind, err := g.AheadBehind(clone); err != nil || ahead != 1 || behind != 0 {
+		t.Errorf("wrong ahead/behind before push (got: %d/%d, err: %v)", ahead, behind, err)
+	}
+	if err := g.Push(clone, nil, false); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+	if got, _ := g.ResolveRef(upstream, "master"); got != after {
+		t.Errorf("upstream wasn't pushed to (got: %s, want: %s)", got, after)
+	}
+
+	// a second clone is behind until it pulls
+	other := filepath.Join(dir, "other")
+	if err := g.Clone(upstream, other); err != nil {
+		t.Fatalf("failed to clone: %v", err)
+	}
+	if err := g.Checkout(other, before); err != nil {
+		t.Fatalf("failed to checkout: %v", err)
+	}
+	if err := g.Checkout(other, "master"); err != nil {
+		t.Fatalf("failed to checkout: %v", err)
+	}
+	if head, _ := g.Head(other); head != after {
+		t.Errorf("wrong head after checkout (got: %s, want: %s)", head, after)
+	}
+}
+
+func TestGoGitBundle(t *testing.T) {
+	dir, upstream := setupGoGit(t)
+	var g GoGit
+
+	bundle := filepath.Join(dir, "castle.bundle")
+	if err := g.CreateBundle(upstream, bundle); err != nil {
+		t.Fatalf("failed to create bundle: %v", err)
+	}
+
+	clone := filepath.Join(dir, "clone")
+	if err := g.Clone(bundle, clone); err != nil {
+		t.Fatalf("failed to clone bundle: %v", err)
+	}
+	want, _ := g.Head(upstream)
+	if head, _ := g.Head(clone); head != want {
+		t.Errorf("wrong head after clone (got: %s, want: %s)", head, want)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(clone, "home/.vimrc")); string(data) != "set nocompatible\n" {
+		t.Errorf("wrong file content after clone: %q", data)
+	}
+	if up, err := g.Upstream(clone); err != nil || up != "origin/master" {
+		t.Errorf("wrong upstream (got: %q, err: %v)", up, err)
+	}
+
+	writeGoGitFile(t, upstream, "home/.vimrc", "syntax on\n")
+	if err := g.CommitAll(upstream, "syntax", false); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := g.CreateBundle(upstream, bundle); err != nil {
+		t.Fatalf("failed to create bundle: %v", err)
+	}
+	if err := g.FetchBundle(clone, bundle); err != nil {
+		t.Fatalf("failed to fetch bundle: %v", err)
+	}
+	// the origin remote branches are left alone
+	if ahead, behind, err := g.AheadBehind(clone); err != nil || ahead != 0 || behind != 0 {
+		t.Errorf("wrong ahead/behind after fetch (got: %d/%d, err: %v)", ahead, behind, err)
+	}
+	if err := g.FastForward(clone, importRefPrefix+"master"); err != nil {
+		t.Fatalf("failed to fast-forward: %v", err)
+	}
+	want, _ = g.Head(upstream)
+	if head, _ := g.Head(clone); head != want {
+		t.Errorf("wrong head after fast-forward (got: %s, want: %s)", head, want)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(clone, "home/.vimrc")); string(data) != "syntax on\n" {
+		t.Errorf("wrong file content after fast-forward: %q", data)
+	}
+}
@@ -0,0 +1,259 @@
+// Package homesick manages castles: git repositories of dotfiles that are
+// linked into a home directory.  It is the library behind the heartsick
+// command and never writes to the terminal or exits on its own; progress is
+// reported through Options.Status, commands it runs (hooks, .homesickrc and
+// git) only use the streams in Options and failures are returned as errors.
+package homesick
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// StatusLevel classifies a progress message.
+type StatusLevel int
+
+const (
+	// StatusInfo is for things that were checked but left alone.
+	StatusInfo StatusLevel = iota
+	// StatusChange is for changes made to the home directory or a castle.
+	StatusChange
+	// StatusProblem is for conflicts and failures that didn't stop the
+	// operation.
+	StatusProblem
+)
+
+// StatusFunc is called to report progress.  Action is a short verb (i.e.
+// `symlink`) and msg describes what it was done to.
+type StatusFunc func(level StatusLevel, action, msg string)
+
+// Options configures a Home.  Any zero field is replaced with its default.
+type Options struct {
+	// HomeDir is the directory castles are linked into.  Defaults to the home
+	// directory of the current user.
+	HomeDir string
+
+	// CastleRoot is the directory castles are cloned into.  Defaults to
+	// `.homesick/repos` in HomeDir.
+	CastleRoot string
+
+	// Git is used for all git operations.  Defaults to ExecGit using the
+	// streams below, or GoGit when the git binary isn't installed.
+	Git GitBackend
+
+	// Stdin, Stdout and Stderr are given to the commands heartsick runs
+	// (hooks, .homesickrc and git commands that prompt or show progress).
+	// Nil streams are connected to the null device.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Status is called to report progress.  Defaults to discarding it.
+	Status StatusFunc
+
+	// SecretKey returns the passphrase or key file content used to encrypt
+	// and decrypt secrets.  Castles with secrets can't be linked without it.
+	SecretKey func() ([]byte, error)
+
+	// MergeTool is run by the shell to resolve ConflictMerge like a git
+	// mergetool.cmd with $LOCAL, $REMOTE, $BASE and $MERGED set.  Defaults
+	// to DefaultMergeTool.
+	MergeTool string
+}
+
+// Home is a home directory along with the castles that are linked into it.
+type Home struct {
+	dir        string
+	castleRoot string
+	git        GitBackend
+	status     StatusFunc
+	secretKey  func() ([]byte, error)
+	mergeTool  string
+
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var errNoSecretKey = errors.New("no secret key configured")
+
+// New returns a Home for the given options.
+func New(opts Options) (*Home, error) {
+	h := &Home{
+		dir:        opts.HomeDir,
+		castleRoot: opts.CastleRoot,
+		git:        opts.Git,
+		status:     opts.Status,
+		secretKey:  opts.SecretKey,
+		mergeTool:  opts.MergeTool,
+		stdin:      opts.Stdin,
+		stdout:     opts.Stdout,
+		stderr:     opts.Stderr,
+	}
+
+	if h.dir == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't find current user: %v", err)
+		}
+		h.dir = u.HomeDir
+	}
+	if h.castleRoot == "" {
+		h.castleRoot = filepath.Join(h.dir, ".homesick/repos")
+	}
+	if h.git == nil {
+		if _, err := exec.LookPath("git"); err != nil {
+			h.git = GoGit{}
+		} else {
+			h.git = ExecGit{Stdin: h.stdin, Stdout: h.stdout, Stderr: h.stderr}
+		}
+	}
+	if h.status == nil {
+		h.status = func(StatusLevel, string, string) {}
+	}
+	if h.mergeTool == "" {
+		h.mergeTool = DefaultMergeTool
+	}
+	if h.secretKey == nil {
+		h.secretKey = func() ([]byte, error) { return nil, errNoSecretKey }
+	}
+	return h, nil
+}
+
+// Dir returns the home directory.
+func (h *Home) Dir() string {
+	return h.dir
+}
+
+// DataDir returns the directory heartsick keeps its own files in.
+func (h *Home) DataDir() string {
+	return filepath.Join(h.dir, ".homesick")
+}
+
+// BackupDir returns the directory files replaced while linking are backed up
+// into.
+func (h *Home) BackupDir() string {
+	return filepath.Join(h.DataDir(), "backups")
+}
+
+// CastleRoot returns the directory castles are cloned into.
+func (h *Home) CastleRoot() string {
+	return h.castleRoot
+}
+
+// CastlePath returns where the named castle is (or would be) cloned.
+func (h *Home) CastlePath(name string) string {
+	return filepath.Join(h.castleRoot, name)
+}
+
+// Git returns the git backend.
+func (h *Home) Git() GitBackend {
+	return h.git
+}
+
+func (h *Home) statusf(level StatusLevel, action, msg string, v ...interface{}) {
+	h.status(level, action, fmt.Sprintf(msg, v...))
+}
+
+// ErrCastleNotExist is returned when loading a castle that hasn't been cloned.
+var ErrCastleNotExist = errors.New("castle does not exist")
+
+// Castle will load a cloned castle by name.
+func (h *Home) Castle(name string) (*Castle, error) {
+	if name == "" {
+		return nil, ErrCastleNotExist
+	}
+
+	path, err := filepath.Abs(h.CastlePath(name))
+	if err != nil {
+		return nil, fmt.Errorf("cannot find path for castle: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrCastleNotExist
+		}
+		return nil, fmt.Errorf("couldn't open castle: %v", err)
+	}
+
+	_, err = os.Stat(filepath.Join(path, deepFilename))
+	deep := err == nil
+
+	return &Castle{
+		Name: name,
+		Path: path,
+		Deep: deep,
+		home: h,
+	}, nil
+}
+
+// Castles returns all castles found in the castle root.
+func (h *Home) Castles() ([]*Castle, error) {
+	files, err := ioutil.ReadDir(h.castleRoot)
+	if os.IsNotExist(err) {
+		return []*Castle{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	castles := make([]*Castle, 0, len(files))
+	for _, f := range files {
+		// skip files
+		if !f.IsDir() {
+			continue
+		}
+
+		castle, err := h.Castle(f.Name())
+		if err != nil {
+			continue
+		}
+
+		castles = append(castles, castle)
+	}
+
+	return castles, nil
+}
+
+var githubPattern = regexp.MustCompile(`^([A-Za-z0-9_-]+/[A-Za-z0-9_-]+)$`)
+
+// ExpandURI will expand a github `user/repo` shorthand into its url.  Any
+// other uri is returned as is.
+func ExpandURI(uri string) string {
+	if githubPattern.MatchString(uri) {
+		return "https://github.com/" + uri + ".git"
+	}
+	return uri
+}
+
+// CastleName returns the default name for a castle cloned from uri.
+func CastleName(uri string) string {
+	return strings.TrimSuffix(filepath.Base(uri), ".git")
+}
+
+// Clone will clone uri as the named castle.  Its post-clone hook isn't run as
+// it comes straight from the remote; callers decide whether to trust it and
+// run it with RunHook.
+func (h *Home) Clone(uri, name string) (*Castle, error) {
+	dest := h.CastlePath(name)
+
+	h.statusf(StatusChange, "git clone", "%s to %s", uri, dest)
+	if err := h.git.Clone(uri, dest); err != nil {
+		return nil, fmt.Errorf("failed to clone '%s': %v", uri, err)
+	}
+	// TODO(bbennett): clone/update submodules?
+
+	c, err := h.Castle(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load castle: %v", err)
+	}
+	return c, nil
+}
@@ -1,8 +1,7 @@
-package main
+package homesick
 
 import (
 	"archive/zip"
-	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -64,7 +63,7 @@ func unzip(src, dest string) error {
 	return nil
 }
 
-func setupHomedir(t *testing.T, base string) (string, func()) {
+func setupHomedir(t *testing.T, base string) (*Home, func()) {
 	t.Helper()
 
 	homeZip := filepath.Join("testdata", base+".zip")
@@ -84,9 +83,12 @@ func setupHomedir(t *testing.T, base string) (string, func()) {
 	}
 
 	t.Logf("setting homedir to %s", tmpHomeDir)
-	homeDir = tmpHomeDir
+	h, err := New(Options{HomeDir: tmpHomeDir})
+	if err != nil {
+		t.Fatalf("failed to create home: %v", err)
+	}
 
-	return homeDir, cleanupFn
+	return h, cleanupFn
 }
 
 func TestLoadCastle(t *testing.T) {
@@ -105,10 +107,11 @@ func TestLoadCastle(t *testing.T) {
 
 	for _, tc := range tt {
 		t.Run(tc.home+":"+tc.castle, func(t *testing.T) {
-			tmpHomePath, cleanup := setupHomedir(t, tc.home)
+			h, cleanup := setupHomedir(t, tc.home)
+			tmpHomePath := h.Dir()
 			defer cleanup()
 
-			got, err := loadCastle(tc.castle)
+			got, err := h.Castle(tc.castle)
 			if err != nil {
 				if !tc.fail {
 					t.Fatalf("unexpected error: %v", err)
@@ -120,13 +123,13 @@ func TestLoadCastle(t *testing.T) {
 				t.Fatal("got nil castle without an error")
 			}
 
-			if got.name != tc.castle {
-				t.Errorf("castle name is wrong (want '%s', got '%s')", tc.castle, got.name)
+			if got.Name != tc.castle {
+				t.Errorf("castle name is wrong (want '%s', got '%s')", tc.castle, got.Name)
 			}
 
 			wantPath, _ := filepath.Abs(filepath.Join(tmpHomePath, ".homesick/repos", tc.castle))
-			if got.path != wantPath {
-				t.Errorf("castle path wrong (want: '%s', got: '%s)", wantPath, got.path)
+			if got.Path != wantPath {
+				t.Errorf("castle path wrong (want: '%s', got: '%s)", wantPath, got.Path)
 			}
 
 		})
@@ -145,17 +148,17 @@ func TestAllCastles(t *testing.T) {
 
 	for _, tc := range tt {
 		t.Run(tc.home, func(t *testing.T) {
-			_, cleanup := setupHomedir(t, tc.home)
+			h, cleanup := setupHomedir(t, tc.home)
 			defer cleanup()
 
-			got, err := allCastles()
+			got, err := h.Castles()
 			if err != nil {
 				t.Errorf("unexpected error: %v", err)
 			}
 
 			gotNames := make([]string, 0, len(got))
 			for _, c := range got {
-				gotNames = append(gotNames, c.name)
+				gotNames = append(gotNames, c.Name)
 			}
 
 			if !cmp.Equal(tc.want, gotNames) {
@@ -164,112 +167,3 @@ func TestAllCastles(t *testing.T) {
 		})
 	}
 }
-
-func TestIsParentPath(t *testing.T) {
-	tt := []struct {
-		parent string
-		path   string
-		want   bool
-	}{
-		{".config", ".config", true},
-		{".config", ".bashrc", false},
-		{".local/share", ".local", true},
-		{".local/share", ".bashrc", false},
-	}
-
-	for _, tc := range tt {
-		t.Run(fmt.Sprintf("%s,%s", tc.parent, tc.path), func(t *testing.T) {
-			got := isParentPath(tc.parent, tc.path)
-
-			if got != tc.want {
-				t.Errorf("unexpected result (got: %t, want %t)", got, tc.want)
-			}
-		})
-	}
-}
-
-func TestCastleLinkables(t *testing.T) {
-	tt := []struct {
-		home, castle string
-		want         []string
-		fail         bool
-	}{
-		{"home1", "dotfiles",
-			[]string{
-				".dir1",
-				".file1",
-				".dir2/.file1",
-				".dir2/.file2",
-				".dir3/.subdir1/.file1",
-				".dir3/.subdir1/.file2",
-			}, false},
-		{"home1", "private", []string{".file1", ".file2"}, false},
-	}
-
-	for _, tc := range tt {
-		t.Run(fmt.Sprintf("%s:%s", tc.home, tc.castle), func(t *testing.T) {
-			_, cleanup := setupHomedir(t, tc.home)
-			defer cleanup()
-
-			castle, err := loadCastle(tc.castle)
-			if err != nil {
-				t.Fatalf("failed to load castle: %v", err)
-			}
-
-			got, _, err := castle.linkables()
-			if err != nil && !tc.fail {
-				t.Errorf("unexpected error: %v", err)
-			}
-
-			if !cmp.Equal(tc.want, got) {
-				t.Errorf("wrong linkables returned:\n%s", cmp.Diff(tc.want, got))
-			}
-
-		})
-	}
-}
-
-func TestCastleSubdirs(t *testing.T) {
-	tt := []struct {
-		home, castle string
-		want         []string
-		fail         bool
-	}{
-		{"home1", "dotfiles", []string{".dir2", ".nonexistent", ".dir3/.subdir1"}, false},
-		{"home1", "private", []string{}, false},
-	}
-
-	for _, tc := range tt {
-		t.Run(fmt.Sprintf("%s:%s", tc.home, tc.castle), func(t *testing.T) {
-			_, cleanup := setupHomedir(t, tc.home)
-			defer cleanup()
-
-			castle, err := loadCastle(tc.castle)
-			if err != nil {
-				t.Fatalf("failed to load castle: %v", err)
-			}
-
-			got, err := castle.subdirs()
-			if err != nil && !tc.fail {
-				t.Errorf("unexpected error: %v", err)
-			}
-
-			if !cmp.Equal(tc.want, got) {
-				t.Errorf("wrong subdirs returned:\n%s", cmp.Diff(tc.want, got))
-			}
-
-		})
-	}
-}
-
-func TestMain(m *testing.M) {
-	// overwrite homedir to make sure tests don't do anything stupid
-	tmpHomeDir, err := ioutil.TempDir("", "")
-	if err != nil {
-		panic(fmt.Sprintf("failed to create failback homedir: %v", err))
-	}
-	homeDir = tmpHomeDir
-
-	flag.Parse()
-	os.Exit(m.Run())
-}
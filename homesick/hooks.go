@@ -0,0 +1,157 @@
+package homesick
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	hooksDirname    = "hooks"
+	trustedFilename = "trusted_hooks"
+)
+
+// Hook is the name of a script in the castle's hooks directory that is run at
+// a specific point of a command.
+type Hook string
+
+const (
+	HookPostClone  Hook = "post-clone"
+	HookPrePull    Hook = "pre-pull"
+	HookPostPull   Hook = "post-pull"
+	HookPreLink    Hook = "pre-link"
+	HookPostLink   Hook = "post-link"
+	HookPreUnlink  Hook = "pre-unlink"
+	HookPostUnlink Hook = "post-unlink"
+)
+
+// HookPath returns the path to the hook script in the castle.
+func (c Castle) HookPath(h Hook) string {
+	return filepath.Join(c.Path, hooksDirname, string(h))
+}
+
+// RunHook will run the hook from the castle if it exists.  Hooks are run from
+// the root of the castle with the following environment variables set:
+//
+//	HEARTSICK_HOOK          name of the hook
+//	HEARTSICK_CASTLE        name of the castle
+//	HEARTSICK_CASTLE_PATH   path to the root of the castle
+//	HEARTSICK_HOME          home directory links are made in
+//	HEARTSICK_CHANGED_FILES newline separated list of changed files
+//
+// Hooks come straight from the castle's remote so they are only run for
+// castles trusted with TrustHooks, otherwise the skipped hook is reported.
+//
+// An error is returned if the hook exits non-zero.
+func (c Castle) RunHook(h Hook, changed []string) error {
+	path := c.HookPath(h)
+
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read hook '%s': %v", h, err)
+	}
+	if fi.IsDir() {
+		return nil
+	}
+	if fi.Mode()&0111 == 0 {
+		c.home.statusf(StatusInfo, "hook", "%s in castle '%s' isn't executable, skipping", h, c.Name)
+		return nil
+	}
+	if !c.home.HooksTrusted(c.Name) {
+		c.home.statusf(StatusProblem, "skip hook", "%s in castle '%s' as its hooks aren't trusted", h, c.Name)
+		return nil
+	}
+
+	c.home.statusf(StatusChange, "hook", "%s in castle '%s'", h, c.Name)
+	cmd := exec.Command(path)
+	cmd.Dir = c.Path
+	cmd.Env = c.Environ(
+		"HEARTSICK_HOOK="+string(h),
+		"HEARTSICK_CHANGED_FILES="+strings.Join(changed, "\n"),
+	)
+	cmd.Stdin = c.home.stdin
+	cmd.Stdout = c.home.stdout
+	cmd.Stderr = c.home.stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("hook '%s' failed: %v", h, err)
+	}
+	return nil
+}
+
+// TrustedHooksPath returns the path of the file listing the castles whose
+// hooks are trusted.
+func (h *Home) TrustedHooksPath() string {
+	return filepath.Join(h.DataDir(), trustedFilename)
+}
+
+// HooksTrusted returns true if the castle's hooks are allowed to run.
+func (h *Home) HooksTrusted(castle string) bool {
+	for _, name := range h.trustedHooks() {
+		if name == castle {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustHooks will allow or stop the castle's hooks from running.  Trust is
+// kept outside of the state file as the state is loaded once per command and
+// saved at the end which would drop trust given in the middle of a command.
+func (h *Home) TrustHooks(castle string, trust bool) error {
+	var names []string
+	for _, name := range h.trustedHooks() {
+		if name != castle {
+			names = append(names, name)
+		}
+	}
+	if trust {
+		names = append(names, castle)
+	}
+	sort.Strings(names)
+
+	if err := os.MkdirAll(h.DataDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+	var data []byte
+	for _, name := range names {
+		data = append(data, name+"\n"...)
+	}
+	if err := ioutil.WriteFile(h.TrustedHooksPath(), data, 0644); err != nil {
+		return fmt.Errorf("failed to write trusted hooks: %v", err)
+	}
+	return nil
+}
+
+func (h *Home) trustedHooks() []string {
+	data, err := ioutil.ReadFile(h.TrustedHooksPath())
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(string(data), "\n") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Environ returns the environment for commands run for the castle: the
+// current environment along with HEARTSICK_CASTLE, HEARTSICK_CASTLE_PATH and
+// HEARTSICK_HOME followed by any extra variables.
+func (c Castle) Environ(extra ...string) []string {
+	env := append(os.Environ(),
+		"HEARTSICK_CASTLE="+c.Name,
+		"HEARTSICK_CASTLE_PATH="+c.Path,
+		"HEARTSICK_HOME="+c.home.dir,
+	)
+	return append(env, extra...)
+}
@@ -0,0 +1,69 @@
+package homesick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunHook(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	// missing hooks are ignored
+	if err := castle.RunHook(HookPreLink, nil); err != nil {
+		t.Fatalf("unexpected error for missing hook: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(castle.Path, hooksDirname), 0755); err != nil {
+		t.Fatalf("failed to create hooks dir: %v", err)
+	}
+
+	out := filepath.Join(tmpHomePath, "hook.out")
+	script := "#!/bin/sh\nprintf '%s|%s|%s|%s' \"$HEARTSICK_HOOK\" \"$HEARTSICK_CASTLE\" \"$PWD\" \"$HEARTSICK_CHANGED_FILES\" > " + out + "\n"
+	if err := ioutil.WriteFile(castle.HookPath(HookPostPull), []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write hook: %v", err)
+	}
+
+	// hooks of untrusted castles are skipped
+	if err := castle.RunHook(HookPostPull, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Fatal("hook of an untrusted castle ran")
+	}
+
+	if err := h.TrustHooks("dotfiles", true); err != nil {
+		t.Fatalf("failed to trust castle: %v", err)
+	}
+	if err := castle.RunHook(HookPostPull, []string{"home/.vimrc", "home/.tmux.conf"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, _ := ioutil.ReadFile(out)
+	want := "post-pull|dotfiles|" + castle.Path + "|home/.vimrc\nhome/.tmux.conf"
+	if string(got) != want {
+		t.Errorf("wrong hook environment (got: %q, want: %q)", got, want)
+	}
+
+	if err := ioutil.WriteFile(castle.HookPath(HookPreLink), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatalf("failed to write hook: %v", err)
+	}
+	if err := castle.RunHook(HookPreLink, nil); err == nil {
+		t.Error("expected failing hook to return an error")
+	}
+
+	if err := h.TrustHooks("dotfiles", false); err != nil {
+		t.Fatalf("failed to revoke trust: %v", err)
+	}
+	if h.HooksTrusted("dotfiles") {
+		t.Error("castle is still trusted after revoking")
+	}
+}
@@ -0,0 +1,836 @@
+package homesick
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type opKind int
+
+const (
+	opMkdir opKind = iota
+	opSymlink
+	opDecrypt
+	opChmod
+)
+
+func (k opKind) String() string {
+	switch k {
+	case opMkdir:
+		return "mkdir"
+	case opSymlink:
+		return "symlink"
+	case opDecrypt:
+		return "decrypt"
+	case opChmod:
+		return "chmod"
+	}
+	return "unknown"
+}
+
+// strategy returns how the result of the operation is recorded in the state.
+// Chmod operations aren't recorded.
+func (k opKind) strategy() LinkStrategy {
+	switch k {
+	case opMkdir:
+		return StrategyMkdir
+	case opDecrypt:
+		return StrategyDecrypt
+	}
+	return StrategySymlink
+}
+
+// linkOp is a single change to the home directory.
+type linkOp struct {
+	kind   opKind
+	source string
+	target string
+
+	// replace is set when an existing target has to be moved out of the way
+	// first.
+	replace bool
+
+	// backup is where a replaced target is kept instead of being removed.
+	backup string
+
+	// adopt is set when the existing target is moved into the castle in
+	// place of the source before linking.
+	adopt bool
+
+	// merge is set when the existing target is merged into the source with
+	// the merge tool before it is replaced.
+	merge bool
+
+	// secret is the name of the secret decrypted to the target.  Secrets are
+	// only decrypted when they have to be compared or written; data and sum
+	// hold the plaintext and its checksum once they are.
+	secret    string
+	sourceSum string
+	data      []byte
+	sum       string
+
+	// mode is the permissions for created directories and chmod.
+	mode os.FileMode
+}
+
+// ConflictDecision is how a conflict with an existing file is resolved.
+type ConflictDecision int
+
+const (
+	// ConflictOverwrite replaces the existing file.
+	ConflictOverwrite ConflictDecision = iota
+	// ConflictOverwriteAll replaces the existing file along with every other
+	// conflict in the plan.
+	ConflictOverwriteAll
+	// ConflictSkip leaves the existing file alone.
+	ConflictSkip
+	// ConflictBackup moves the existing file into the backup directory
+	// before replacing it.
+	ConflictBackup
+	// ConflictAdopt moves the existing file into the castle in place of the
+	// castle's version and links it back.
+	ConflictAdopt
+	// ConflictMerge merges the existing file into the castle's version with
+	// the merge tool when the plan is applied and replaces it, keeping it in
+	// the backup directory in case the merge missed something.
+	ConflictMerge
+	// ConflictQuit stops planning without changing anything.
+	ConflictQuit
+)
+
+// ErrLinkAborted is returned when a conflict was resolved with ConflictQuit.
+var ErrLinkAborted = errors.New("link aborted")
+
+// Conflict is an existing file not owned by heartsick that is in the way of a
+// link.
+type Conflict struct {
+	// Source is the file in the castle.
+	Source string
+	// Target is the existing file in the home directory.
+	Target string
+	// Secret is set when the source is an encrypted secret which can't be
+	// diffed, merged or adopted.
+	Secret bool
+	// Mergeable is set when both are regular files so ConflictMerge can be
+	// used.
+	Mergeable bool
+}
+
+// ConflictFunc is called when planning a link that would replace an existing
+// file not owned by heartsick.
+type ConflictFunc func(c Conflict) ConflictDecision
+
+// LinkPlan is the list of changes needed to link a castle.  Nothing is touched
+// on disk until the plan is applied.
+type LinkPlan struct {
+	castle *Castle
+	ops    []*linkOp
+
+	// identical are links that already exist and need no changes.
+	identical []*linkOp
+
+	// existing are subdirs that already exist.
+	existing []string
+
+	modes    []ModeRule
+	state    *State
+	conflict ConflictFunc
+	allYes   bool
+	planned  map[string]bool
+	// replaced are links to whole directories that are planned to be
+	// replaced with real directories.
+	replaced map[string]bool
+
+	// backupDir is where files replaced with ConflictBackup are kept.
+	backupDir string
+}
+
+// PlanLink will work out everything that needs to happen to link the castle
+// into the home directory.  Conflicts are resolved up front with the conflict
+// function.
+func (c Castle) PlanLink(state *State, conflict ConflictFunc) (*LinkPlan, error) {
+	links, subdirs, err := c.Linkables()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find links: %v", err)
+	}
+
+	modes, err := c.Modes()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &LinkPlan{
+		castle:   &c,
+		modes:    modes,
+		state:    state,
+		conflict: conflict,
+		planned:  make(map[string]bool),
+		replaced: make(map[string]bool),
+
+		backupDir: filepath.Join(c.home.BackupDir(), time.Now().Format("20060102-150405")),
+	}
+	castleHome := c.HomePath()
+	homeDir := c.home.dir
+
+	for _, subdir := range subdirs {
+		subdir := filepath.Join(homeDir, subdir)
+		exists, err := p.planDir(subdir)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			c.home.status(StatusInfo, "exists", subdir)
+			p.existing = append(p.existing, subdir)
+		}
+	}
+
+	for _, link := range links {
+		op := &linkOp{
+			kind:   opSymlink,
+			source: filepath.Join(castleHome, link),
+			target: filepath.Join(homeDir, link),
+		}
+		if err := p.planFile(op); err != nil {
+			return nil, err
+		}
+	}
+
+	secrets, err := c.Secrets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find secrets: %v", err)
+	}
+
+	for _, secret := range secrets {
+		blob, err := ioutil.ReadFile(c.SecretPath(secret))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret: %v", err)
+		}
+
+		op := &linkOp{
+			kind:      opDecrypt,
+			source:    c.SecretPath(secret),
+			target:    filepath.Join(homeDir, secret),
+			secret:    secret,
+			sourceSum: Checksum(blob),
+		}
+		if _, err := p.planDir(filepath.Dir(op.target)); err != nil {
+			return nil, err
+		}
+
+		// secrets that haven't changed on either side since they were last
+		// decrypted are left alone without asking for the key.
+		if e := state.Lookup(op.target); e != nil && e.Castle == c.Name && e.Strategy == StrategyDecrypt &&
+			e.SourceChecksum == op.sourceSum && !p.inReplaced(op.target) && e.Intact() {
+			c.home.status(StatusInfo, "identical", op.source)
+			op.sum = e.Checksum
+			p.identical = append(p.identical, op)
+			continue
+		}
+
+		if err := p.planFile(op); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.planModes(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// planModes will plan to set the permissions from the castle's
+// .homesick_modes on everything the plan creates or that already exists.
+// Conflicting files that were skipped are left alone.
+func (p *LinkPlan) planModes() error {
+	if len(p.modes) == 0 {
+		return nil
+	}
+
+	// existing targets are only changed if needed while everything else is
+	// checked when the plan is applied.
+	unchanged := append([]string{}, p.existing...)
+	for _, op := range p.identical {
+		unchanged = append(unchanged, op.target)
+	}
+	var changed []string
+	for _, op := range p.ops {
+		changed = append(changed, op.target)
+	}
+
+	for _, target := range append(unchanged, changed...) {
+		rel, err := filepath.Rel(p.castle.home.dir, target)
+		if err != nil {
+			return err
+		}
+		mode, ok := ModeFor(p.modes, rel)
+		if !ok {
+			continue
+		}
+		if ok, err := chmodable(target); err == nil && !ok {
+			p.castle.home.statusf(StatusInfo, "skip chmod", "%s is a file linked from the castle", target)
+			continue
+		}
+
+		if fi, err := os.Stat(target); err == nil && fi.Mode().Perm() == mode && !contains(changed, target) {
+			continue
+		}
+		p.ops = append(p.ops, &linkOp{kind: opChmod, target: target, mode: mode})
+	}
+	return nil
+}
+
+// contains returns true if s is in list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// planDir will plan to create the directory along with any missing parents so
+// they can be recorded and reverted.  Returns true if the directory already
+// exists.
+func (p *LinkPlan) planDir(path string) (bool, error) {
+	var missing []string
+	for dir := path; dir != p.castle.home.dir && !p.planned[dir]; dir = filepath.Dir(dir) {
+		if p.inReplaced(dir) {
+			missing = append([]string{dir}, missing...)
+			continue
+		}
+		fi, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			missing = append([]string{dir}, missing...)
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read subdir '%s': %v", dir, err)
+		}
+		if fi.IsDir() {
+			if dir == path {
+				return true, nil
+			}
+			break
+		}
+
+		// a directory that was previously linked wholesale from this castle
+		// is replaced with a real directory.
+		existingLink, _ := os.Readlink(dir)
+		owned := p.state.Owns(p.castle.Name, dir)
+		if fi.Mode()&os.ModeSymlink == 0 || !(owned || isParentPath(existingLink, p.castle.HomePath())) {
+			return false, fmt.Errorf("subdir '%s' already exists but isn't a directory", dir)
+		}
+		p.ops = append(p.ops, &linkOp{kind: opMkdir, target: dir, mode: p.dirMode(dir), replace: true})
+		p.planned[dir] = true
+		p.replaced[dir] = true
+		break
+	}
+
+	for _, dir := range missing {
+		p.ops = append(p.ops, &linkOp{kind: opMkdir, target: dir, mode: p.dirMode(dir)})
+		p.planned[dir] = true
+	}
+	return false, nil
+}
+
+// inReplaced returns true if path is inside a directory link that is planned
+// to be replaced.  Such paths still resolve through the old link into the
+// castle so they are planned as if they didn't exist yet.
+func (p *LinkPlan) inReplaced(path string) bool {
+	for dir := filepath.Dir(path); dir != p.castle.home.dir && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if p.replaced[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// dirMode returns the permissions for a directory created in the home
+// directory.
+func (p *LinkPlan) dirMode(dir string) os.FileMode {
+	if rel, err := filepath.Rel(p.castle.home.dir, dir); err == nil {
+		if mode, ok := ModeFor(p.modes, rel); ok {
+			return mode
+		}
+	}
+	return defaultDirMode
+}
+
+// planFile will plan a symlink or decrypted secret, checking for an existing
+// target and resolving conflicts.
+func (p *LinkPlan) planFile(op *linkOp) error {
+	if p.inReplaced(op.target) {
+		p.ops = append(p.ops, op)
+		return nil
+	}
+
+	fi, err := os.Lstat(op.target)
+	if os.IsNotExist(err) {
+		p.ops = append(p.ops, op)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read '%s': %v", op.target, err)
+	}
+
+	if op.kind == opDecrypt {
+		if err := p.castle.decrypt(op); err != nil {
+			return err
+		}
+	}
+	identical, err := op.identical(fi)
+	if err != nil {
+		return err
+	}
+	if identical {
+		p.castle.home.status(StatusInfo, "identical", op.source)
+		p.identical = append(p.identical, op)
+		return nil
+	}
+
+	// anything this castle created and nobody touched since is safe to
+	// replace without asking.  Files from other castles are conflicts.
+	if p.state.Owns(p.castle.Name, op.target) {
+		p.castle.home.statusf(StatusInfo, "update", "%s owned by castle '%s'", op.target, p.castle.Name)
+	} else if !p.allYes {
+		if e := p.state.Lookup(op.target); e != nil && e.Castle != p.castle.Name && e.Intact() {
+			p.castle.home.statusf(StatusProblem, "conflict", "%s is linked from castle '%s'", op.target, e.Castle)
+		} else {
+			p.castle.home.statusf(StatusProblem, "conflict", "%s exists", op.target)
+		}
+		c := Conflict{
+			Source:    op.source,
+			Target:    op.target,
+			Secret:    op.kind == opDecrypt,
+			Mergeable: op.kind == opSymlink && fi.Mode().IsRegular() && isRegular(op.source),
+		}
+		switch decision := p.conflict(c); decision {
+		case ConflictSkip:
+			p.castle.home.status(StatusInfo, "skip", op.target)
+			return nil
+		case ConflictQuit:
+			return ErrLinkAborted
+		case ConflictOverwriteAll:
+			p.allYes = true
+		case ConflictBackup, ConflictMerge:
+			if decision == ConflictMerge {
+				if !c.Mergeable {
+					return fmt.Errorf("'%s' can't be merged, only files can be", op.target)
+				}
+				op.merge = true
+			}
+			rel, err := filepath.Rel(p.castle.home.dir, op.target)
+			if err != nil {
+				return err
+			}
+			op.backup = filepath.Join(p.backupDir, rel)
+		case ConflictAdopt:
+			if op.kind != opSymlink {
+				return fmt.Errorf("'%s' can't be adopted as it is a secret, use `secret add` instead", op.target)
+			}
+			op.adopt = true
+			p.ops = append(p.ops, op)
+			return nil
+		}
+	}
+
+	op.replace = true
+	p.ops = append(p.ops, op)
+	return nil
+}
+
+// isRegular returns true if path is a regular file.
+func isRegular(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// identical returns true if the existing target is already what the operation
+// would create.
+func (op *linkOp) identical(fi os.FileInfo) (bool, error) {
+	switch op.kind {
+	case opSymlink:
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return false, nil
+		}
+		existingLink, err := os.Readlink(op.target)
+		if err != nil {
+			return false, fmt.Errorf("failed to read link: %v", err)
+		}
+		return existingLink == op.source, nil
+	case opDecrypt:
+		if !fi.Mode().IsRegular() {
+			return false, nil
+		}
+		existing, err := ioutil.ReadFile(op.target)
+		if err != nil {
+			return false, fmt.Errorf("failed to read '%s': %v", op.target, err)
+		}
+		return bytes.Equal(existing, op.data), nil
+	}
+	return false, nil
+}
+
+// Changed returns every target in the home directory the plan creates or
+// replaces.
+func (p *LinkPlan) Changed() []string {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, op := range p.ops {
+		if !seen[op.target] {
+			seen[op.target] = true
+			targets = append(targets, op.target)
+		}
+	}
+	return targets
+}
+
+// linkTx tracks the changes made to the home directory while applying a plan so
+// they can be reverted.
+type linkTx struct {
+	home      *Home
+	castle    *Castle
+	backupDir string
+	undo      []func() error
+}
+
+// do will apply a single operation moving any replaced target into the backup
+// directory.
+func (tx *linkTx) do(op *linkOp) error {
+	if op.merge {
+		if err := tx.merge(op); err != nil {
+			return err
+		}
+	}
+
+	if op.replace {
+		backup := filepath.Join(tx.backupDir, strconv.Itoa(len(tx.undo)))
+		if op.backup != "" {
+			backup = op.backup
+			tx.home.statusf(StatusChange, "backup", "%s to %s", op.target, backup)
+			if err := os.MkdirAll(filepath.Dir(backup), 0755); err != nil {
+				return fmt.Errorf("failed to create backup directory: %v", err)
+			}
+		}
+		if err := os.Rename(op.target, backup); err != nil {
+			return fmt.Errorf("failed to move '%s' out of the way: %v", op.target, err)
+		}
+		tx.undo = append(tx.undo, func() error {
+			return os.Rename(backup, op.target)
+		})
+	}
+
+	if op.adopt {
+		if err := tx.adopt(op); err != nil {
+			return err
+		}
+	}
+
+	switch op.kind {
+	case opMkdir:
+		tx.home.status(StatusChange, "mkdir", op.target)
+		if err := os.Mkdir(op.target, op.mode); err != nil {
+			return fmt.Errorf("failed to create subdir '%s': %v", op.target, err)
+		}
+	case opSymlink:
+		tx.home.statusf(StatusChange, "symlink", "%s to %s", op.source, op.target)
+		if err := os.Symlink(op.source, op.target); err != nil {
+			return fmt.Errorf("failed to symlink file: %v", err)
+		}
+	case opDecrypt:
+		tx.home.statusf(StatusChange, "decrypt", "%s to %s", op.source, op.target)
+		if err := tx.castle.decrypt(op); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(op.target, op.data, 0600); err != nil {
+			return fmt.Errorf("failed to write secret: %v", err)
+		}
+	case opChmod:
+		if ok, err := chmodable(op.target); err != nil {
+			return fmt.Errorf("failed to read '%s': %v", op.target, err)
+		} else if !ok {
+			tx.home.statusf(StatusInfo, "skip chmod", "%s is a file linked from the castle", op.target)
+			return nil
+		}
+		fi, err := os.Stat(op.target)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %v", op.target, err)
+		}
+		oldMode := fi.Mode().Perm()
+		if oldMode == op.mode {
+			return nil
+		}
+
+		tx.home.statusf(StatusChange, "chmod", "%#o %s", op.mode, op.target)
+		if err := os.Chmod(op.target, op.mode); err != nil {
+			return fmt.Errorf("failed to set permissions on '%s': %v", op.target, err)
+		}
+		tx.undo = append(tx.undo, func() error {
+			return os.Chmod(op.target, oldMode)
+		})
+		return nil
+	}
+
+	tx.undo = append(tx.undo, func() error {
+		return os.Remove(op.target)
+	})
+	return nil
+}
+
+// adopt will move the existing target into the castle in place of the
+// source, creating any missing parents in the castle.  The castle's version
+// is kept with the other backups until the plan is applied.
+func (tx *linkTx) adopt(op *linkOp) error {
+	if _, err := os.Lstat(op.source); err == nil {
+		old := filepath.Join(tx.backupDir, strconv.Itoa(len(tx.undo)))
+		if err := os.Rename(op.source, old); err != nil {
+			return fmt.Errorf("failed to move '%s' out of the way: %v", op.source, err)
+		}
+		tx.undo = append(tx.undo, func() error {
+			return os.Rename(old, op.source)
+		})
+	} else if os.IsNotExist(err) {
+		var missing []string
+		for dir := filepath.Dir(op.source); ; dir = filepath.Dir(dir) {
+			if _, err := os.Lstat(dir); err == nil {
+				break
+			}
+			missing = append([]string{dir}, missing...)
+		}
+		for _, dir := range missing {
+			dir := dir
+			if err := os.Mkdir(dir, defaultDirMode); err != nil {
+				return fmt.Errorf("failed to create '%s': %v", dir, err)
+			}
+			tx.undo = append(tx.undo, func() error {
+				return os.Remove(dir)
+			})
+		}
+	} else {
+		return fmt.Errorf("failed to read '%s': %v", op.source, err)
+	}
+
+	tx.home.statusf(StatusChange, "adopt", "%s into %s", op.target, op.source)
+	if err := os.Rename(op.target, op.source); err != nil {
+		return fmt.Errorf("failed to move '%s' into the castle: %v", op.target, err)
+	}
+	tx.undo = append(tx.undo, func() error {
+		return os.Rename(op.source, op.target)
+	})
+	return nil
+}
+
+// rollback will revert every applied change in reverse order.
+func (tx *linkTx) rollback() error {
+	var errs []string
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if err := tx.undo[i](); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s (backups kept in '%s')", strings.Join(errs, "; "), tx.backupDir)
+	}
+	return os.RemoveAll(tx.backupDir)
+}
+
+// Apply will make all of the changes in the plan.  If any change fails all
+// changes already made are reverted, including restoring replaced files, and
+// the original error is returned.  The state is only updated on success.
+func (p *LinkPlan) Apply(state *State) error {
+	backupRoot := p.castle.home.DataDir()
+	if err := os.MkdirAll(backupRoot, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %v", err)
+	}
+	backupDir, err := ioutil.TempDir(backupRoot, "backup-")
+	if err != nil {
+		return fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	tx := &linkTx{home: p.castle.home, castle: p.castle, backupDir: backupDir}
+	for _, op := range p.ops {
+		if err := tx.do(op); err != nil {
+			p.castle.home.statusf(StatusProblem, "rollback", "reverting changes to castle '%s'", p.castle.Name)
+			if rerr := tx.rollback(); rerr != nil {
+				return fmt.Errorf("%v (rollback failed: %v)", err, rerr)
+			}
+			return err
+		}
+	}
+
+	if err := os.RemoveAll(backupDir); err != nil {
+		p.castle.home.statusf(StatusProblem, "error", "failed to remove backup directory: %v", err)
+	}
+
+	for _, ops := range [][]*linkOp{p.identical, p.ops} {
+		for _, op := range ops {
+			if op.kind == opChmod {
+				continue
+			}
+			if op.kind == opDecrypt {
+				state.RecordSecret(p.castle.Name, op.source, op.target, op.sum, op.sourceSum)
+				continue
+			}
+			state.Record(p.castle.Name, op.kind.strategy(), op.source, op.target)
+		}
+	}
+	return nil
+}
+
+// Link will link the castle into the home directory running the link hooks
+// and saving the state.  Returns the targets that were changed.
+func (c Castle) Link(state *State, conflict ConflictFunc) ([]string, error) {
+	if err := c.RunHook(HookPreLink, nil); err != nil {
+		return nil, fmt.Errorf("aborting link: %v", err)
+	}
+
+	plan, err := c.PlanLink(state, conflict)
+	if err == ErrLinkAborted {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to link castle '%s': %v", c.Name, err)
+	}
+
+	if err := plan.Apply(state); err != nil {
+		return nil, fmt.Errorf("failed to link castle '%s': %v", c.Name, err)
+	}
+
+	if err := state.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save state: %v", err)
+	}
+
+	changed := plan.Changed()
+	if err := c.RunHook(HookPostLink, changed); err != nil {
+		return changed, err
+	}
+	return changed, nil
+}
+
+// Unlink will remove everything linked from the castle running the unlink
+// hooks and saving the state.  Returns the targets that were linked.
+func (c Castle) Unlink(state *State) ([]string, error) {
+	entries := state.CastleEntries(c.Name)
+	if len(entries) == 0 {
+		c.home.statusf(StatusInfo, "unlink", "nothing linked from castle '%s'", c.Name)
+		return nil, nil
+	}
+
+	if err := c.RunHook(HookPreUnlink, nil); err != nil {
+		return nil, fmt.Errorf("aborting unlink: %v", err)
+	}
+
+	var targets []string
+	for _, e := range entries {
+		targets = append(targets, e.Target)
+	}
+
+	c.home.RemoveEntries(state, entries)
+
+	if err := state.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save state: %v", err)
+	}
+
+	if err := c.RunHook(HookPostUnlink, targets); err != nil {
+		return targets, err
+	}
+	return targets, nil
+}
+
+// Prune will remove links to files that no longer exist in their castle and
+// save the state.
+func (h *Home) Prune(state *State) error {
+	var stale []*StateEntry
+	for _, e := range state.Entries {
+		if e.Strategy == StrategyMkdir {
+			continue
+		}
+		if _, err := os.Lstat(e.Source); os.IsNotExist(err) {
+			stale = append(stale, e)
+		}
+	}
+
+	h.RemoveEntries(state, stale)
+
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("failed to save state: %v", err)
+	}
+	return nil
+}
+
+// RemoveEntries will remove targets that heartsick created for the given
+// entries from the home directory.  Links are removed first followed by any
+// directories that are left empty.  Targets that were modified since they
+// were created are left alone.  Entries are only forgotten once their target
+// is gone so anything left behind is still tracked.
+func (h *Home) RemoveEntries(state *State, entries []*StateEntry) {
+	// deepest paths first so directories are empty before they are removed
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Target > entries[j].Target
+	})
+
+	for _, mkdir := range []bool{false, true} {
+		for _, e := range entries {
+			if (e.Strategy == StrategyMkdir) != mkdir {
+				continue
+			}
+			if _, err := os.Lstat(e.Target); os.IsNotExist(err) {
+				state.Forget(e.Target)
+				continue
+			}
+			if !e.Intact() {
+				h.status(StatusInfo, "modified", e.Target)
+				continue
+			}
+
+			if err := os.Remove(e.Target); err != nil {
+				if mkdir {
+					h.status(StatusInfo, "not empty", e.Target)
+				} else {
+					h.statusf(StatusProblem, "error", "failed to remove '%s': %v", e.Target, err)
+				}
+				continue
+			}
+			if mkdir {
+				h.status(StatusChange, "rmdir", e.Target)
+			} else {
+				h.status(StatusChange, "unlink", e.Target)
+			}
+			state.Forget(e.Target)
+		}
+	}
+}
+
+// writeFileAtomic writes data to a temporary file next to path before renaming
+// it into place so the file is never seen partially written or with the
+// wrong permissions.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
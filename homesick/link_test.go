@@ -0,0 +1,297 @@
+package homesick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkPlanApply(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	// existing file that conflicts with the castle
+	existing := filepath.Join(tmpHomePath, ".file1")
+	if err := ioutil.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	state, _ := h.LoadState()
+	var prompted []string
+	plan, err := castle.PlanLink(state, func(c Conflict) ConflictDecision {
+		prompted = append(prompted, c.Target)
+		return ConflictOverwrite
+	})
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+
+	if len(prompted) != 1 || prompted[0] != existing {
+		t.Errorf("expected a single conflict for %s, got %v", existing, prompted)
+	}
+
+	if err := plan.Apply(state); err != nil {
+		t.Fatalf("failed to apply: %v", err)
+	}
+
+	dest, err := os.Readlink(existing)
+	if err != nil || dest != filepath.Join(castle.HomePath(), ".file1") {
+		t.Errorf("conflicting file wasn't linked (got: %s, %v)", dest, err)
+	}
+
+	if e := state.Lookup(filepath.Join(tmpHomePath, ".dir3")); e == nil || e.Strategy != StrategyMkdir {
+		t.Errorf("parent directory wasn't recorded: %+v", e)
+	}
+
+	// linking again should find everything identical
+	plan, err = castle.PlanLink(state, func(c Conflict) ConflictDecision {
+		t.Errorf("unexpected conflict for %s", c.Target)
+		return ConflictSkip
+	})
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if len(plan.ops) != 0 {
+		t.Errorf("expected no operations, got %d", len(plan.ops))
+	}
+}
+
+func TestLinkWholeDirToDeep(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	state, _ := h.LoadState()
+	if _, err := castle.Link(state, func(Conflict) ConflictDecision { return ConflictOverwrite }); err != nil {
+		t.Fatalf("failed to link: %v", err)
+	}
+	dir := filepath.Join(tmpHomePath, ".dir1")
+	if dest, err := os.Readlink(dir); err != nil || dest != filepath.Join(castle.HomePath(), ".dir1") {
+		t.Fatalf("directory wasn't linked whole (got: %s, %v)", dest, err)
+	}
+
+	// the files in the linked directory are planned as if it was already gone
+	// instead of being found through the old link
+	castle.Deep = true
+	if _, err := castle.Link(state, func(c Conflict) ConflictDecision {
+		t.Errorf("unexpected conflict for %s", c.Target)
+		return ConflictSkip
+	}); err != nil {
+		t.Fatalf("failed to link deep: %v", err)
+	}
+
+	if fi, err := os.Lstat(dir); err != nil || !fi.IsDir() {
+		t.Fatalf("directory link wasn't replaced with a directory: %v", err)
+	}
+	file := filepath.Join(dir, ".file1")
+	if dest, err := os.Readlink(file); err != nil || dest != filepath.Join(castle.HomePath(), ".dir1/.file1") {
+		t.Errorf("file in the directory wasn't linked (got: %s, %v)", dest, err)
+	}
+	if !state.Owns("dotfiles", file) {
+		t.Errorf("file in the directory wasn't recorded")
+	}
+}
+
+func TestLinkApplyRollback(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	existing := filepath.Join(tmpHomePath, ".file1")
+	if err := ioutil.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	newDir := filepath.Join(tmpHomePath, ".newdir")
+
+	plan := &LinkPlan{
+		castle: castle,
+		ops: []*linkOp{
+			{kind: opSymlink, source: filepath.Join(castle.HomePath(), ".file1"), target: existing, replace: true},
+			{kind: opMkdir, target: newDir},
+			{kind: opSymlink, source: "/nowhere", target: filepath.Join(tmpHomePath, ".missing/.file")},
+		},
+	}
+
+	state, _ := h.LoadState()
+	if err := plan.Apply(state); err == nil {
+		t.Fatal("expected apply to fail")
+	}
+
+	data, err := ioutil.ReadFile(existing)
+	if err != nil || string(data) != "old" {
+		t.Errorf("replaced file wasn't restored (got: %q, %v)", data, err)
+	}
+
+	if _, err := os.Lstat(newDir); !os.IsNotExist(err) {
+		t.Errorf("created directory wasn't removed: %v", err)
+	}
+
+	if len(state.Entries) != 0 {
+		t.Errorf("state shouldn't be updated on failure, got %d entries", len(state.Entries))
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(tmpHomePath, ".homesick/backup-*"))
+	if len(backups) != 0 {
+		t.Errorf("backup directories left behind: %v", backups)
+	}
+}
+
+func TestLinkConflictDecisions(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	file1 := filepath.Join(tmpHomePath, ".file1")
+	if err := ioutil.WriteFile(file1, []byte("home .file1"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	// directories are adopted as a whole
+	dir1 := filepath.Join(tmpHomePath, ".dir1")
+	if err := os.Mkdir(dir1, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir1, "mine"), []byte("home mine"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	// quitting leaves everything alone
+	state, _ := h.LoadState()
+	_, err = castle.PlanLink(state, func(c Conflict) ConflictDecision {
+		return ConflictQuit
+	})
+	if err != ErrLinkAborted {
+		t.Fatalf("expected ErrLinkAborted, got: %v", err)
+	}
+
+	decisions := map[string]ConflictDecision{
+		file1: ConflictBackup,
+		dir1:  ConflictAdopt,
+	}
+	plan, err := castle.PlanLink(state, func(c Conflict) ConflictDecision {
+		return decisions[c.Target]
+	})
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if err := plan.Apply(state); err != nil {
+		t.Fatalf("failed to apply: %v", err)
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(h.BackupDir(), "*", ".file1"))
+	if len(backups) != 1 {
+		t.Fatalf("expected a single backup, got: %v", backups)
+	}
+	if data, _ := ioutil.ReadFile(backups[0]); string(data) != "home .file1" {
+		t.Errorf("wrong backup content: %q", data)
+	}
+
+	source := filepath.Join(castle.HomePath(), ".dir1")
+	if dest, err := os.Readlink(dir1); err != nil || dest != source {
+		t.Errorf("adopted directory wasn't linked (got: %s, %v)", dest, err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(source, "mine")); string(data) != "home mine" {
+		t.Errorf("castle doesn't have the adopted content: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(source, ".file1")); !os.IsNotExist(err) {
+		t.Errorf("castle's version should be replaced: %v", err)
+	}
+}
+
+func TestLinkOtherCastleConflict(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	// a link made by another castle isn't ours to replace
+	file1 := filepath.Join(tmpHomePath, ".file1")
+	other := filepath.Join(h.CastlePath("other"), "home", ".file1")
+	if err := os.Symlink(other, file1); err != nil {
+		t.Fatalf("failed to symlink: %v", err)
+	}
+	state, _ := h.LoadState()
+	state.Record("other", StrategySymlink, other, file1)
+
+	var prompted []string
+	_, err = castle.PlanLink(state, func(c Conflict) ConflictDecision {
+		prompted = append(prompted, c.Target)
+		return ConflictSkip
+	})
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if len(prompted) != 1 || prompted[0] != file1 {
+		t.Errorf("expected a single conflict for %s, got %v", file1, prompted)
+	}
+}
+
+func TestRemoveEntries(t *testing.T) {
+	h, cleanup := setupHomedir(t, "emptyHome")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	state, _ := h.LoadState()
+	source := filepath.Join(h.CastlePath("dotfiles"), "home")
+
+	// removed
+	link := filepath.Join(tmpHomePath, ".link")
+	os.Symlink(filepath.Join(source, ".link"), link)
+	state.Record("dotfiles", StrategySymlink, filepath.Join(source, ".link"), link)
+
+	// modified since it was linked
+	modified := filepath.Join(tmpHomePath, ".modified")
+	ioutil.WriteFile(modified, []byte("mine"), 0644)
+	state.Record("dotfiles", StrategySymlink, filepath.Join(source, ".modified"), modified)
+
+	// not empty
+	dir := filepath.Join(tmpHomePath, ".dir")
+	os.Mkdir(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "mine"), []byte("mine"), 0644)
+	state.Record("dotfiles", StrategyMkdir, "", dir)
+
+	// already gone
+	gone := filepath.Join(tmpHomePath, ".gone")
+	state.Record("dotfiles", StrategySymlink, filepath.Join(source, ".gone"), gone)
+
+	h.RemoveEntries(state, state.CastleEntries("dotfiles"))
+
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("link wasn't removed: %v", err)
+	}
+	for _, target := range []string{link, gone} {
+		if state.Lookup(target) != nil {
+			t.Errorf("%s should be forgotten", target)
+		}
+	}
+	for _, target := range []string{modified, dir} {
+		if state.Lookup(target) == nil {
+			t.Errorf("%s should still be recorded", target)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package homesick
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ManifestCastle is a castle a machine should have installed.
+type ManifestCastle struct {
+	// Name is the name the castle is cloned as.
+	Name string
+	// URI is where the castle is cloned from.  Github `user/repo` shorthands
+	// are expanded.
+	URI string
+	// Ref is an optional branch, tag or commit the castle is checked out at.
+	Ref string
+	// Link is whether the castle is linked into the home directory.
+	Link bool
+	// RC is whether the castle's .homesickrc is run.
+	RC bool
+}
+
+// ParseManifest will read a manifest of castles from r.  Every castle is a
+// section named after the castle with a required `uri`, an optional `ref`
+// and the `link` (default true) and `rc` (default false) options.
+//
+//	[dotfiles]
+//	uri = me/dotfiles
+//	rc = true
+//
+//	[work]
+//	uri = git@example.com:me/work.git
+//	ref = stable
+//	link = false
+func ParseManifest(r io.Reader, filename string) ([]ManifestCastle, error) {
+	sections, err := parseSections(r, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	castles := make([]ManifestCastle, 0, len(sections))
+	seen := make(map[string]bool)
+	for _, s := range sections {
+		if seen[s.name] {
+			return nil, &ParseError{filename, s.line, fmt.Sprintf("duplicate castle '%s'", s.name)}
+		}
+		seen[s.name] = true
+
+		if strings.ContainsAny(s.name, `/\`) || s.name == "." || s.name == ".." {
+			return nil, &ParseError{filename, s.line, fmt.Sprintf("invalid castle name '%s'", s.name)}
+		}
+
+		c := ManifestCastle{Name: s.name, Link: true}
+		for _, e := range s.entries {
+			switch e.key {
+			case "uri":
+				c.URI = ExpandURI(unquote(e.value))
+			case "ref":
+				c.Ref = unquote(e.value)
+			case "link", "rc":
+				b, err := strconv.ParseBool(unquote(e.value))
+				if err != nil {
+					return nil, &ParseError{filename, e.line, fmt.Sprintf("'%s' must be true or false", e.key)}
+				}
+				if e.key == "link" {
+					c.Link = b
+				} else {
+					c.RC = b
+				}
+			default:
+				return nil, &ParseError{filename, e.line, fmt.Sprintf("unknown key '%s'", e.key)}
+			}
+		}
+		if c.URI == "" {
+			return nil, &ParseError{filename, s.line, fmt.Sprintf("castle '%s' has no uri", s.name)}
+		}
+		castles = append(castles, c)
+	}
+	return castles, nil
+}
+
+// LoadManifest will read a manifest of castles from a file.
+func LoadManifest(path string) ([]ManifestCastle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	defer f.Close()
+
+	return ParseManifest(f, path)
+}
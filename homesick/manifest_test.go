@@ -0,0 +1,55 @@
+package homesick
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseManifest(t *testing.T) {
+	tt := []struct {
+		name  string
+		input string
+		want  []ManifestCastle
+		line  int
+	}{
+		{"empty", "", []ManifestCastle{}, 0},
+		{
+			"valid",
+			"[dotfiles]\nuri = me/dotfiles\nrc = true\n\n[work]\nuri = \"git@example.com:me/work.git\"\nref = stable\nlink = false\n",
+			[]ManifestCastle{
+				{Name: "dotfiles", URI: "https://github.com/me/dotfiles.git", Link: true, RC: true},
+				{Name: "work", URI: "git@example.com:me/work.git", Ref: "stable"},
+			},
+			0,
+		},
+		{"no uri", "[dotfiles]\nrc = true\n", nil, 1},
+		{"bad bool", "[dotfiles]\nuri = a\nlink = maybe\n", nil, 3},
+		{"unknown key", "[dotfiles]\nurl = a\n", nil, 2},
+		{"duplicate", "[dotfiles]\nuri = a\n[dotfiles]\nuri = b\n", nil, 3},
+		{"bad name", "[..]\nuri = a\n", nil, 1},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseManifest(strings.NewReader(tc.input), "manifest")
+			if tc.line != 0 {
+				perr, ok := err.(*ParseError)
+				if !ok {
+					t.Fatalf("expected ParseError, got: %v", err)
+				}
+				if perr.Line != tc.line {
+					t.Errorf("wrong error line (want: %d, got: %d)", tc.line, perr.Line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cmp.Equal(tc.want, got) {
+				t.Errorf("wrong castles:\n%s", cmp.Diff(tc.want, got))
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package homesick
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// DefaultMergeTool is the 3-way editor used when Options.MergeTool isn't set.
+const DefaultMergeTool = `vimdiff "$LOCAL" "$MERGED" "$REMOTE"`
+
+// mergeBaseVersions is how many versions of a castle file are searched for
+// the base of a merge.
+const mergeBaseVersions = 20
+
+// mergeBase returns the version of the castle file at source from its git
+// history that is closest to the home file at target.  Home files usually
+// started out as a copy of the castle file so that is the version both sides
+// changed from.  Returns nil if the file has no history.
+func (c Castle) mergeBase(source, target string) ([]byte, error) {
+	rel, err := filepath.Rel(c.Path, source)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := c.home.git.FileVersions(c.Path, rel, mergeBaseVersions)
+	if err != nil {
+		return nil, err
+	}
+	local, err := ioutil.ReadFile(target)
+	if err != nil {
+		return nil, err
+	}
+	localLines := difflib.SplitLines(string(local))
+
+	var base []byte
+	best := -1.0
+	for _, v := range versions {
+		// ties go to the newest version
+		if ratio := difflib.NewMatcher(difflib.SplitLines(string(v)), localLines).Ratio(); ratio > best {
+			base, best = v, ratio
+		}
+	}
+	return base, nil
+}
+
+// merge will run the merge tool to merge the existing target into the
+// castle's version at source.  The castle's version is restored on rollback.
+func (tx *linkTx) merge(op *linkOp) error {
+	fi, err := os.Stat(op.source)
+	if err != nil {
+		return fmt.Errorf("failed to read '%s': %v", op.source, err)
+	}
+	original, err := ioutil.ReadFile(op.source)
+	if err != nil {
+		return fmt.Errorf("failed to read '%s': %v", op.source, err)
+	}
+
+	base, err := tx.castle.mergeBase(op.source, op.target)
+	if err != nil {
+		tx.home.statusf(StatusProblem, "merge", "no history for %s, merging without a base: %v", op.source, err)
+		base = nil
+	}
+
+	dir, err := ioutil.TempDir(tx.backupDir, "merge-")
+	if err != nil {
+		return fmt.Errorf("failed to create merge directory: %v", err)
+	}
+	remote := filepath.Join(dir, "REMOTE."+filepath.Base(op.source))
+	basePath := filepath.Join(dir, "BASE."+filepath.Base(op.source))
+	if err := ioutil.WriteFile(remote, original, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(basePath, base, 0600); err != nil {
+		return err
+	}
+
+	// the tool may have written part of the result before failing
+	tx.undo = append(tx.undo, func() error {
+		return writeFileAtomic(op.source, original, fi.Mode().Perm())
+	})
+
+	tx.home.statusf(StatusChange, "merge", "%s into %s", op.target, op.source)
+	cmd := exec.Command("sh", "-c", tx.home.mergeTool)
+	cmd.Env = append(os.Environ(),
+		"LOCAL="+op.target,
+		"REMOTE="+remote,
+		"BASE="+basePath,
+		"MERGED="+op.source,
+	)
+	cmd.Stdin = tx.home.stdin
+	cmd.Stdout = tx.home.stdout
+	cmd.Stderr = tx.home.stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("merge tool failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package homesick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// versionsGit is a GitBackend returning fixed versions for every file.
+type versionsGit struct {
+	GitBackend
+	versions [][]byte
+}
+
+func (g versionsGit) FileVersions(path, file string, max int) ([][]byte, error) {
+	return g.versions, nil
+}
+
+func TestLinkMerge(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	h.git = versionsGit{GitBackend: h.git, versions: [][]byte{
+		[]byte("newest\n"),
+		[]byte("a\nb\nc\n"),
+		[]byte("oldest\n"),
+	}}
+	h.mergeTool = `cat "$BASE" "$REMOTE" "$LOCAL" > "$MERGED"`
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+	source := filepath.Join(castle.HomePath(), ".file1")
+	original, err := ioutil.ReadFile(source)
+	if err != nil {
+		t.Fatalf("failed to read castle file: %v", err)
+	}
+
+	file1 := filepath.Join(tmpHomePath, ".file1")
+	if err := ioutil.WriteFile(file1, []byte("a\nb\nc\nd\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	state, _ := h.LoadState()
+	plan, err := castle.PlanLink(state, func(c Conflict) ConflictDecision {
+		if c.Target == file1 && !c.Mergeable {
+			t.Errorf("%s should be mergeable", c.Target)
+		}
+		return ConflictMerge
+	})
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(source); string(data) != string(original) {
+		t.Fatalf("castle file changed while planning: %q", data)
+	}
+
+	if err := plan.Apply(state); err != nil {
+		t.Fatalf("failed to apply: %v", err)
+	}
+
+	// the version closest to the home file is the base
+	want := "a\nb\nc\n" + string(original) + "a\nb\nc\nd\n"
+	if data, _ := ioutil.ReadFile(source); string(data) != want {
+		t.Errorf("wrong merge result (got: %q, want: %q)", data, want)
+	}
+	if dest, err := os.Readlink(file1); err != nil || dest != source {
+		t.Errorf("merged file wasn't linked (got: %s, %v)", dest, err)
+	}
+	backups, _ := filepath.Glob(filepath.Join(h.BackupDir(), "*", ".file1"))
+	if len(backups) != 1 {
+		t.Errorf("expected the home file to be backed up, got: %v", backups)
+	}
+}
+
+func TestLinkMergeRollback(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	h.git = versionsGit{GitBackend: h.git}
+	h.mergeTool = `echo partial > "$MERGED"; exit 1`
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+	source := filepath.Join(castle.HomePath(), ".file1")
+	original, _ := ioutil.ReadFile(source)
+
+	file1 := filepath.Join(tmpHomePath, ".file1")
+	if err := ioutil.WriteFile(file1, []byte("home .file1"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	state, _ := h.LoadState()
+	plan, err := castle.PlanLink(state, func(c Conflict) ConflictDecision {
+		return ConflictMerge
+	})
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if err := plan.Apply(state); err == nil {
+		t.Fatal("expected the failing merge tool to fail the link")
+	}
+
+	if data, _ := ioutil.ReadFile(source); string(data) != string(original) {
+		t.Errorf("castle file wasn't restored: %q", data)
+	}
+	if data, _ := ioutil.ReadFile(file1); string(data) != "home .file1" {
+		t.Errorf("home file was changed: %q", data)
+	}
+}
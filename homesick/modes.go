@@ -0,0 +1,167 @@
+package homesick
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	modesFilename              = ".homesick_modes"
+	defaultDirMode os.FileMode = 0755
+)
+
+// ModeRule sets the permissions for any path in the home directory matching
+// pattern.
+type ModeRule struct {
+	Pattern string
+	Mode    os.FileMode
+}
+
+// ParseModes will read permission rules from r, one per line in the form of
+// `path mode` where mode is octal (i.e `.ssh 0700`).  Paths are relative to
+// the home directory and may be glob patterns.  Blank lines and lines starting
+// with '#' are ignored.
+func ParseModes(r io.Reader, filename string) ([]ModeRule, error) {
+	rules := []ModeRule{}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, &ParseError{filename, lineNo, "expected a path and a mode"}
+		}
+
+		pattern, err := cleanEntry(fields[0])
+		if err != nil {
+			return nil, &ParseError{filename, lineNo, err.Error()}
+		}
+
+		mode, err := strconv.ParseUint(fields[1], 8, 32)
+		if err != nil || mode > 0777 {
+			return nil, &ParseError{filename, lineNo, fmt.Sprintf("invalid mode '%s'", fields[1])}
+		}
+
+		rules = append(rules, ModeRule{Pattern: pattern, Mode: os.FileMode(mode)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read modes file '%s': %v", filename, err)
+	}
+
+	return rules, nil
+}
+
+// ModeFor returns the mode for a path relative to the home directory.  When
+// multiple rules match the last one wins.
+func ModeFor(rules []ModeRule, rel string) (os.FileMode, bool) {
+	var (
+		mode  os.FileMode
+		found bool
+	)
+	for _, r := range rules {
+		if ok, _ := filepath.Match(r.Pattern, rel); ok {
+			mode, found = r.Mode, true
+		}
+	}
+	return mode, found
+}
+
+// Modes will read the .homesick_modes file from the castle.
+func (c Castle) Modes() ([]ModeRule, error) {
+	modesFile := filepath.Join(c.Path, modesFilename)
+
+	f, err := os.Open(modesFile)
+	if os.IsNotExist(err) {
+		return []ModeRule{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read modes file '%s': %v", modesFile, err)
+	}
+	defer f.Close()
+
+	return ParseModes(f, modesFile)
+}
+
+// ModeDrift is a path in the home directory whose permissions don't match the
+// castle's rules.
+type ModeDrift struct {
+	Path string
+	Want os.FileMode
+	Got  os.FileMode
+}
+
+// chmodable returns true if the permissions of path should be set.  Files
+// linked from a castle are left alone as git records their mode and changing
+// it would show up as a change to the castle.  Directories linked from a
+// castle have the mode set on the castle's directory, which git ignores.
+func chmodable(path string) (bool, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return false, err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return true, nil
+	}
+	fi, err = os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return fi.IsDir(), nil
+}
+
+// CheckModes returns every path in the home directory matching the castle's
+// .homesick_modes whose permissions have drifted.  Files linked from a castle
+// are skipped as their mode is whatever git checked out.
+func (c Castle) CheckModes() ([]ModeDrift, error) {
+	rules, err := c.Modes()
+	if err != nil {
+		return nil, err
+	}
+
+	var drift []ModeDrift
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		matches, err := filepath.Glob(filepath.Join(c.home.dir, rule.Pattern))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range matches {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+
+			rel, err := filepath.Rel(c.home.dir, path)
+			if err != nil {
+				return nil, err
+			}
+			want, _ := ModeFor(rules, rel)
+
+			ok, err := chmodable(path)
+			if os.IsNotExist(err) || (err == nil && !ok) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+
+			if fi.Mode().Perm() != want {
+				drift = append(drift, ModeDrift{Path: path, Want: want, Got: fi.Mode().Perm()})
+			}
+		}
+	}
+	return drift, nil
+}
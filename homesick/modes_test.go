@@ -0,0 +1,137 @@
+package homesick
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseModes(t *testing.T) {
+	tt := []struct {
+		name  string
+		input string
+		want  []ModeRule
+		line  int
+	}{
+		{"simple", "# modes\n.ssh 0700\n.ssh/* 600\r\n", []ModeRule{{".ssh", 0700}, {".ssh/*", 0600}}, 0},
+		{"missing mode", ".ssh\n", nil, 1},
+		{"bad mode", ".ssh 0700\n.gnupg 0800\n", nil, 2},
+		{"too large", ".ssh 01777\n", nil, 1},
+		{"absolute", "/etc/passwd 0600\n", nil, 1},
+		{"extra fields", ".ssh 0700 0600\n", nil, 1},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseModes(strings.NewReader(tc.input), modesFilename)
+			if tc.line != 0 {
+				perr, ok := err.(*ParseError)
+				if !ok {
+					t.Fatalf("expected ParseError, got: %v", err)
+				}
+				if perr.Line != tc.line {
+					t.Errorf("wrong error line (want: %d, got: %d)", tc.line, perr.Line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !cmp.Equal(tc.want, got) {
+				t.Errorf("wrong rules returned:\n%s", cmp.Diff(tc.want, got))
+			}
+		})
+	}
+}
+
+func TestModeFor(t *testing.T) {
+	rules := []ModeRule{{".ssh", 0700}, {".ssh/*", 0644}, {".ssh/id_*", 0600}}
+
+	tt := []struct {
+		path string
+		want os.FileMode
+		ok   bool
+	}{
+		{".ssh", 0700, true},
+		{".ssh/config", 0644, true},
+		{".ssh/id_rsa", 0600, true},
+		{".bashrc", 0, false},
+	}
+
+	for _, tc := range tt {
+		got, ok := ModeFor(rules, tc.path)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("ModeFor(%s) = %#o, %t (want %#o, %t)", tc.path, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestLinkModes(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	modes := ".dir3 0700\n.dir3/.subdir1 0750\n.file1 0600\n"
+	if err := ioutil.WriteFile(filepath.Join(castle.Path, modesFilename), []byte(modes), 0644); err != nil {
+		t.Fatalf("failed to write modes: %v", err)
+	}
+
+	// .file1 is linked from the castle so its mode is left to git
+	castleFile := filepath.Join(castle.HomePath(), ".file1")
+	before, err := os.Stat(castleFile)
+	if err != nil {
+		t.Fatalf("failed to stat castle file: %v", err)
+	}
+
+	state, _ := h.LoadState()
+	plan, err := castle.PlanLink(state, func(c Conflict) ConflictDecision {
+		return ConflictOverwrite
+	})
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if err := plan.Apply(state); err != nil {
+		t.Fatalf("failed to apply: %v", err)
+	}
+
+	if fi, _ := os.Stat(castleFile); fi.Mode().Perm() != before.Mode().Perm() {
+		t.Errorf("castle file mode changed (got: %#o, want: %#o)", fi.Mode().Perm(), before.Mode().Perm())
+	}
+
+	for path, want := range map[string]os.FileMode{".dir3": 0700, ".dir3/.subdir1": 0750, ".dir2": 0755} {
+		fi, err := os.Stat(filepath.Join(tmpHomePath, path))
+		if err != nil {
+			t.Errorf("failed to stat %s: %v", path, err)
+			continue
+		}
+		if fi.Mode().Perm() != want {
+			t.Errorf("wrong mode for %s (got: %#o, want: %#o)", path, fi.Mode().Perm(), want)
+		}
+	}
+
+	drift, err := castle.CheckModes()
+	if err != nil {
+		t.Fatalf("failed to check modes: %v", err)
+	}
+	if len(drift) != 0 {
+		t.Errorf("unexpected drift: %+v", drift)
+	}
+
+	if err := os.Chmod(filepath.Join(tmpHomePath, ".dir3"), 0755); err != nil {
+		t.Fatalf("failed to chmod: %v", err)
+	}
+	drift, _ = castle.CheckModes()
+	if len(drift) != 1 || drift[0].Got != 0755 || drift[0].Want != 0700 {
+		t.Errorf("expected drift for .dir3, got: %+v", drift)
+	}
+}
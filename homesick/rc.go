@@ -0,0 +1,85 @@
+package homesick
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const rcFilename = ".homesickrc"
+
+// RCPath returns the path to the castle's .homesickrc.
+func (c Castle) RCPath() string {
+	return filepath.Join(c.Path, rcFilename)
+}
+
+// RCChecksum returns the checksum of the castle's .homesickrc or an empty
+// string if the castle doesn't have one.
+func (c Castle) RCChecksum() (string, error) {
+	data, err := ioutil.ReadFile(c.RCPath())
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read '%s': %v", c.RCPath(), err)
+	}
+	return Checksum(data), nil
+}
+
+// rcCommand returns the command used to run an rc file.  The interpreter is
+// taken from the shebang line and files without one are run with ruby like
+// homesick does.
+func rcCommand(path string) (*exec.Cmd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return exec.Command("ruby", path), nil
+	}
+
+	if !bytes.HasPrefix(line, []byte("#!")) {
+		return exec.Command("ruby", path), nil
+	}
+
+	fields := strings.Fields(string(line[2:]))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("'%s' has an empty shebang", path)
+	}
+	return exec.Command(fields[0], append(fields[1:], path)...), nil
+}
+
+// RunRC will run the castle's .homesickrc from the root of the castle with
+// the same environment as hooks.  Returns false if the castle doesn't have
+// one.
+func (c Castle) RunRC() (bool, error) {
+	path := c.RCPath()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	cmd, err := rcCommand(path)
+	if err != nil {
+		return false, err
+	}
+
+	c.home.statusf(StatusChange, "rc", "%s in castle '%s'", strings.Join(cmd.Args, " "), c.Name)
+	cmd.Dir = c.Path
+	cmd.Env = c.Environ()
+	cmd.Stdin = c.home.stdin
+	cmd.Stdout = c.home.stdout
+	cmd.Stderr = c.home.stderr
+
+	if err := cmd.Run(); err != nil {
+		return true, fmt.Errorf("%s in castle '%s' failed: %v", rcFilename, c.Name, err)
+	}
+	return true, nil
+}
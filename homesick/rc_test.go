@@ -0,0 +1,50 @@
+package homesick
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunRC(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	defer cleanup()
+
+	castle, err := h.Castle("dotfiles")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	// castles without an rc are skipped
+	if ran, err := castle.RunRC(); ran || err != nil {
+		t.Fatalf("expected missing rc to be skipped (ran: %v, err: %v)", ran, err)
+	}
+	if sum, _ := castle.RCChecksum(); sum != "" {
+		t.Errorf("expected no checksum, got: %s", sum)
+	}
+
+	out := filepath.Join(h.Dir(), "rc.out")
+	script := "#!/bin/sh -e\nprintf '%s|%s' \"$HEARTSICK_CASTLE\" \"$PWD\" > " + out + "\n"
+	if err := ioutil.WriteFile(castle.RCPath(), []byte(script), 0644); err != nil {
+		t.Fatalf("failed to write rc: %v", err)
+	}
+
+	ran, err := castle.RunRC()
+	if !ran || err != nil {
+		t.Fatalf("expected rc to run (ran: %v, err: %v)", ran, err)
+	}
+	got, _ := ioutil.ReadFile(out)
+	if want := "dotfiles|" + castle.Path; string(got) != want {
+		t.Errorf("wrong rc environment (got: %q, want: %q)", got, want)
+	}
+	if sum, _ := castle.RCChecksum(); sum != Checksum([]byte(script)) {
+		t.Errorf("wrong checksum: %s", sum)
+	}
+
+	if err := ioutil.WriteFile(castle.RCPath(), []byte("#!/bin/sh\nexit 3\n"), 0644); err != nil {
+		t.Fatalf("failed to write rc: %v", err)
+	}
+	if _, err := castle.RunRC(); err == nil {
+		t.Error("expected failing rc to return an error")
+	}
+}
@@ -0,0 +1,246 @@
+package homesick
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	secretsDirname = "secrets"
+	secretExt      = ".enc"
+
+	secretSaltSize = 16
+	secretKeySize  = 32
+
+	// argon2id parameters from the second recommendation of RFC 9106.
+	secretTime    = 3
+	secretMemory  = 64 * 1024
+	secretThreads = 4
+
+	// secretIterV1 is the PBKDF2 iteration count of HSECRET1 blobs.
+	secretIterV1 = 200000
+)
+
+// secretMagic is the header of every encrypted blob.  It doubles as the format
+// version: HSECRET1 keys are derived with PBKDF2-SHA256 and HSECRET2 keys with
+// argon2id.  Only HSECRET2 is written.
+var (
+	secretMagic   = []byte("HSECRET2")
+	secretMagicV1 = []byte("HSECRET1")
+)
+
+// ErrSecretFormat is returned when decrypting something that isn't a secret.
+var ErrSecretFormat = errors.New("not a heartsick secret")
+
+func secretCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret will encrypt plaintext with a key derived from material.  The
+// name is authenticated along with the content so blobs can't be swapped
+// between files.
+func EncryptSecret(material []byte, name string, plaintext []byte) ([]byte, error) {
+	salt := make([]byte, secretSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := secretCipher(argon2.IDKey(material, salt, secretTime, secretMemory, secretThreads, secretKeySize))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	blob := make([]byte, 0, len(secretMagic)+len(salt)+len(nonce)+len(plaintext)+aead.Overhead())
+	blob = append(blob, secretMagic...)
+	blob = append(blob, salt...)
+	blob = append(blob, nonce...)
+	return aead.Seal(blob, nonce, plaintext, []byte(filepath.ToSlash(name))), nil
+}
+
+// DecryptSecret reverses EncryptSecret.
+func DecryptSecret(material []byte, name string, blob []byte) ([]byte, error) {
+	var kdf func(salt []byte) []byte
+	switch {
+	case bytes.HasPrefix(blob, secretMagic):
+		kdf = func(salt []byte) []byte {
+			return argon2.IDKey(material, salt, secretTime, secretMemory, secretThreads, secretKeySize)
+		}
+	case bytes.HasPrefix(blob, secretMagicV1):
+		kdf = func(salt []byte) []byte {
+			return pbkdf2.Key(material, salt, secretIterV1, secretKeySize, sha256.New)
+		}
+	default:
+		return nil, ErrSecretFormat
+	}
+	blob = blob[len(secretMagic):]
+	if len(blob) < secretSaltSize {
+		return nil, ErrSecretFormat
+	}
+	salt, blob := blob[:secretSaltSize], blob[secretSaltSize:]
+
+	aead, err := secretCipher(kdf(salt))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(blob) < aead.NonceSize() {
+		return nil, ErrSecretFormat
+	}
+	nonce, ciphertext := blob[:aead.NonceSize()], blob[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(filepath.ToSlash(name)))
+	if err != nil {
+		return nil, errors.New("wrong passphrase or key file")
+	}
+	return plaintext, nil
+}
+
+// Checksum returns the hex encoded sha256 of data.
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// SecretsPath returns the directory holding the encrypted secrets in the
+// castle.
+func (c Castle) SecretsPath() string {
+	return filepath.Join(c.Path, secretsDirname)
+}
+
+// SecretPath returns the encrypted blob for a file relative to the home dir.
+func (c Castle) SecretPath(name string) string {
+	return filepath.Join(c.SecretsPath(), name+secretExt)
+}
+
+// Secrets returns all of the secrets in the castle relative to the home
+// directory.
+func (c Castle) Secrets() ([]string, error) {
+	base := c.SecretsPath()
+
+	secrets := []string{}
+	err := filepath.Walk(base, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == base {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if fi.IsDir() || !strings.HasSuffix(path, secretExt) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(base, strings.TrimSuffix(path, secretExt))
+		if err != nil {
+			return err
+		}
+		secrets = append(secrets, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// ReadSecret will decrypt a secret from the castle.
+func (c Castle) ReadSecret(name string) ([]byte, error) {
+	blob, err := ioutil.ReadFile(c.SecretPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := c.home.secretKey()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := DecryptSecret(key, name, blob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret '%s': %v", name, err)
+	}
+	return plaintext, nil
+}
+
+// decrypt will read the plaintext of a secret for op if it hasn't been
+// already.
+func (c Castle) decrypt(op *linkOp) error {
+	if op.data != nil {
+		return nil
+	}
+	data, err := c.ReadSecret(op.secret)
+	if err != nil {
+		return err
+	}
+	op.data, op.sum = data, Checksum(data)
+	return nil
+}
+
+// AddSecret will encrypt plaintext into the castle and make sure the plaintext
+// can never be committed from the castle's home directory.
+func (c Castle) AddSecret(name string, plaintext []byte) error {
+	key, err := c.home.secretKey()
+	if err != nil {
+		return err
+	}
+
+	blob, err := EncryptSecret(key, name, plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret: %v", err)
+	}
+
+	path := c.SecretPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, blob, 0644); err != nil {
+		return err
+	}
+
+	return c.Ignore("/home/" + filepath.ToSlash(name))
+}
+
+// Ignore will add a pattern to the castle's .gitignore if it isn't already
+// there.
+func (c Castle) Ignore(pattern string) error {
+	path := filepath.Join(c.Path, ".gitignore")
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == pattern {
+			return nil
+		}
+	}
+
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	data = append(data, pattern+"\n"...)
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,160 @@
+package homesick
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestDecryptSecretV1(t *testing.T) {
+	// secrets encrypted before the switch to argon2id can still be read
+	salt := []byte("0123456789abcdef")
+	nonce := []byte("0123456789ab")
+	aead, err := secretCipher(pbkdf2.Key([]byte("passphrase"), salt, secretIterV1, secretKeySize, sha256.New))
+	if err != nil {
+		t.Fatalf("failed to create cipher: %v", err)
+	}
+	blob := append([]byte("HSECRET1"), salt...)
+	blob = append(blob, nonce...)
+	blob = aead.Seal(blob, nonce, []byte("secret"), []byte(".netrc"))
+
+	got, err := DecryptSecret([]byte("passphrase"), ".netrc", blob)
+	if err != nil {
+		t.Fatalf("failed to decrypt: %v", err)
+	}
+	if string(got) != "secret" {
+		t.Errorf("wrong plaintext: %q", got)
+	}
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	plaintext := []byte("machine example.com login me password hunter2\n")
+
+	blob, err := EncryptSecret([]byte("passphrase"), ".netrc", plaintext)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+
+	got, err := DecryptSecret([]byte("passphrase"), ".netrc", blob)
+	if err != nil {
+		t.Fatalf("failed to decrypt: %v", err)
+	}
+	if string(got) != string(plaintext) {
+		t.Errorf("wrong plaintext (got: %q, want: %q)", got, plaintext)
+	}
+
+	if _, err := DecryptSecret([]byte("wrong"), ".netrc", blob); err == nil {
+		t.Error("expected wrong passphrase to fail")
+	}
+
+	if _, err := DecryptSecret([]byte("passphrase"), ".ssh/config", blob); err == nil {
+		t.Error("expected blob for another file to fail")
+	}
+
+	if _, err := DecryptSecret([]byte("passphrase"), ".netrc", plaintext); err != ErrSecretFormat {
+		t.Errorf("expected format error, got: %v", err)
+	}
+}
+
+func TestLinkSecrets(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	h.secretKey = func() ([]byte, error) { return []byte("passphrase"), nil }
+
+	castle, err := h.Castle("private")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+
+	plaintext := []byte("secret")
+	if err := castle.AddSecret(".aws/credentials", plaintext); err != nil {
+		t.Fatalf("failed to add secret: %v", err)
+	}
+
+	ignore, _ := ioutil.ReadFile(filepath.Join(castle.Path, ".gitignore"))
+	if string(ignore) != "/home/.aws/credentials\n" {
+		t.Errorf("plaintext not ignored in castle: %q", ignore)
+	}
+
+	state, _ := h.LoadState()
+	plan, err := castle.PlanLink(state, func(c Conflict) ConflictDecision {
+		t.Errorf("unexpected conflict for %s", c.Target)
+		return ConflictSkip
+	})
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if err := plan.Apply(state); err != nil {
+		t.Fatalf("failed to apply: %v", err)
+	}
+
+	target := filepath.Join(tmpHomePath, ".aws/credentials")
+	fi, err := os.Lstat(target)
+	if err != nil {
+		t.Fatalf("secret wasn't decrypted: %v", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("wrong permissions on secret: %v", fi.Mode().Perm())
+	}
+	if data, _ := ioutil.ReadFile(target); string(data) != string(plaintext) {
+		t.Errorf("wrong secret content: %q", data)
+	}
+
+	if e := state.Lookup(target); e == nil || !e.Intact() {
+		t.Errorf("secret not recorded in state: %+v", e)
+	}
+
+	// unchanged secrets are linked again without the key
+	h.secretKey = func() ([]byte, error) { return nil, errNoSecretKey }
+	plan, err = castle.PlanLink(state, func(c Conflict) ConflictDecision {
+		t.Errorf("unexpected conflict for %s", c.Target)
+		return ConflictSkip
+	})
+	if err != nil {
+		t.Fatalf("failed to plan again: %v", err)
+	}
+	if err := plan.Apply(state); err != nil {
+		t.Fatalf("failed to apply again: %v", err)
+	}
+	if e := state.Lookup(target); e == nil || !e.Intact() {
+		t.Errorf("secret not kept in state: %+v", e)
+	}
+}
+
+func TestLinkSecretConflict(t *testing.T) {
+	h, cleanup := setupHomedir(t, "home1")
+	defer cleanup()
+
+	h.secretKey = func() ([]byte, error) { return []byte("passphrase"), nil }
+
+	castle, err := h.Castle("private")
+	if err != nil {
+		t.Fatalf("failed to load castle: %v", err)
+	}
+	if err := castle.AddSecret(".netrc", []byte("secret")); err != nil {
+		t.Fatalf("failed to add secret: %v", err)
+	}
+	target := filepath.Join(h.Dir(), ".netrc")
+	if err := ioutil.WriteFile(target, []byte("mine"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	state, _ := h.LoadState()
+	var got []Conflict
+	_, err = castle.PlanLink(state, func(c Conflict) ConflictDecision {
+		got = append(got, c)
+		return ConflictAdopt
+	})
+	if err == nil {
+		t.Error("expected an error adopting a secret")
+	}
+	if len(got) != 1 || !got[0].Secret || got[0].Source != castle.SecretPath(".netrc") {
+		t.Errorf("wrong conflicts: %+v", got)
+	}
+}
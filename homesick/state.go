@@ -0,0 +1,216 @@
+package homesick
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const stateFilename = "state.json"
+
+// LinkStrategy is how heartsick created a target in the home directory.
+type LinkStrategy string
+
+const (
+	StrategySymlink LinkStrategy = "symlink"
+	StrategyMkdir   LinkStrategy = "mkdir"
+	StrategyDecrypt LinkStrategy = "decrypt"
+)
+
+// StateEntry records a single file or directory created by heartsick.
+type StateEntry struct {
+	Castle   string       `json:"castle"`
+	Source   string       `json:"source,omitempty"`
+	Target   string       `json:"target"`
+	Strategy LinkStrategy `json:"strategy"`
+	Created  time.Time    `json:"created"`
+
+	// Checksum is the sha256 of the content for files heartsick wrote
+	// instead of linked.
+	Checksum string `json:"checksum,omitempty"`
+
+	// SourceChecksum is the sha256 of the encrypted secret a file was
+	// decrypted from.
+	SourceChecksum string `json:"source_checksum,omitempty"`
+}
+
+// Intact returns true if the target on disk is still what heartsick created.
+// Anything that was changed or replaced since is no longer owned by heartsick.
+func (e *StateEntry) Intact() bool {
+	fi, err := os.Lstat(e.Target)
+	if err != nil {
+		return false
+	}
+
+	switch e.Strategy {
+	case StrategySymlink:
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return false
+		}
+		dest, err := os.Readlink(e.Target)
+		return err == nil && dest == e.Source
+	case StrategyMkdir:
+		return fi.IsDir()
+	case StrategyDecrypt:
+		if !fi.Mode().IsRegular() {
+			return false
+		}
+		data, err := ioutil.ReadFile(e.Target)
+		return err == nil && Checksum(data) == e.Checksum
+	}
+	return false
+}
+
+// State is the per-machine record of everything heartsick has created in
+// the home directory.
+type State struct {
+	path    string
+	Entries []*StateEntry `json:"entries"`
+
+	// RC is the checksum of the .homesickrc last run for each castle.
+	RC map[string]string `json:"rc,omitempty"`
+}
+
+// StatePath returns the path of the state file.
+func (h *Home) StatePath() string {
+	return filepath.Join(h.DataDir(), stateFilename)
+}
+
+// LoadState will read the state file.  A missing state file results in an
+// empty state.
+func (h *Home) LoadState() (*State, error) {
+	s := &State{path: h.StatePath()}
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file '%s': %v", s.path, err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state file '%s': %v", s.path, err)
+	}
+	return s, nil
+}
+
+// Save will atomically write the state file.
+func (s *State) Save() error {
+	sort.Slice(s.Entries, func(i, j int) bool {
+		return s.Entries[i].Target < s.Entries[j].Target
+	})
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+
+	tmpFile := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmpFile, s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
+}
+
+// Lookup returns the entry for the given target or nil if heartsick never
+// created it.
+func (s *State) Lookup(target string) *StateEntry {
+	for _, e := range s.Entries {
+		if e.Target == target {
+			return e
+		}
+	}
+	return nil
+}
+
+// Owns returns true if target was created by the named castle and hasn't
+// been modified since.  Targets created by other castles aren't owned.
+func (s *State) Owns(castle, target string) bool {
+	e := s.Lookup(target)
+	return e != nil && e.Castle == castle && e.Intact()
+}
+
+// Record will add an entry to the state replacing any existing entry for the
+// same target.  Recording an identical entry again is a no-op.
+func (s *State) Record(castle string, strategy LinkStrategy, source, target string) {
+	s.RecordFile(castle, strategy, source, target, "")
+}
+
+// RecordFile is like Record but also stores the checksum of a file written by
+// heartsick.
+func (s *State) RecordFile(castle string, strategy LinkStrategy, source, target, sum string) {
+	s.record(&StateEntry{
+		Castle:   castle,
+		Source:   source,
+		Target:   target,
+		Strategy: strategy,
+		Checksum: sum,
+	})
+}
+
+// RecordSecret is like RecordFile for a decrypted secret also storing the
+// checksum of the encrypted source so unchanged secrets aren't decrypted
+// again.
+func (s *State) RecordSecret(castle, source, target, sum, sourceSum string) {
+	s.record(&StateEntry{
+		Castle:         castle,
+		Source:         source,
+		Target:         target,
+		Strategy:       StrategyDecrypt,
+		Checksum:       sum,
+		SourceChecksum: sourceSum,
+	})
+}
+
+func (s *State) record(entry *StateEntry) {
+	if e := s.Lookup(entry.Target); e != nil && e.Castle == entry.Castle && e.Strategy == entry.Strategy &&
+		e.Source == entry.Source && e.Checksum == entry.Checksum && e.SourceChecksum == entry.SourceChecksum {
+		return
+	}
+	s.Forget(entry.Target)
+	entry.Created = time.Now().UTC()
+	s.Entries = append(s.Entries, entry)
+}
+
+// Forget will remove the entry for the target from the state.
+func (s *State) Forget(target string) {
+	entries := s.Entries[:0]
+	for _, e := range s.Entries {
+		if e.Target != target {
+			entries = append(entries, e)
+		}
+	}
+	s.Entries = entries
+}
+
+// CastleEntries returns all entries that belong to the named castle.
+func (s *State) CastleEntries(name string) []*StateEntry {
+	var entries []*StateEntry
+	for _, e := range s.Entries {
+		if e.Castle == name {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// RecordRC will record that the castle's .homesickrc with the given checksum
+// was run.
+func (s *State) RecordRC(castle, sum string) {
+	if s.RC == nil {
+		s.RC = make(map[string]string)
+	}
+	s.RC[castle] = sum
+}
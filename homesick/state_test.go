@@ -0,0 +1,85 @@
+package homesick
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStateSaveLoad(t *testing.T) {
+	h, cleanup := setupHomedir(t, "noRepos")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	state, err := h.LoadState()
+	if err != nil {
+		t.Fatalf("failed to load empty state: %v", err)
+	}
+	if len(state.Entries) != 0 {
+		t.Fatalf("expected empty state, got %d entries", len(state.Entries))
+	}
+
+	state.Record("dotfiles", StrategySymlink, "/castle/.b", filepath.Join(tmpHomePath, ".b"))
+	state.Record("dotfiles", StrategyMkdir, "", filepath.Join(tmpHomePath, ".a"))
+	state.Record("private", StrategySymlink, "/castle/.b2", filepath.Join(tmpHomePath, ".b"))
+	if err := state.Save(); err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+
+	got, err := h.LoadState()
+	if err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+
+	var targets []string
+	for _, e := range got.Entries {
+		targets = append(targets, e.Castle+":"+filepath.Base(e.Target))
+	}
+	want := []string{"dotfiles:.a", "private:.b"}
+	if !cmp.Equal(want, targets) {
+		t.Errorf("wrong entries:\n%s", cmp.Diff(want, targets))
+	}
+
+	if e := got.Lookup(filepath.Join(tmpHomePath, ".b")); e == nil || e.Source != "/castle/.b2" {
+		t.Errorf("lookup returned wrong entry: %+v", e)
+	}
+
+	got.Forget(filepath.Join(tmpHomePath, ".b"))
+	if len(got.CastleEntries("private")) != 0 {
+		t.Error("forgotten entry still exists")
+	}
+}
+
+func TestStateEntryIntact(t *testing.T) {
+	h, cleanup := setupHomedir(t, "noRepos")
+	tmpHomePath := h.Dir()
+	defer cleanup()
+
+	source := filepath.Join(tmpHomePath, "source")
+	target := filepath.Join(tmpHomePath, "target")
+	if err := os.Symlink(source, target); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+
+	tt := []struct {
+		name  string
+		entry StateEntry
+		want  bool
+	}{
+		{"symlink", StateEntry{Source: source, Target: target, Strategy: StrategySymlink}, true},
+		{"relinked", StateEntry{Source: "/elsewhere", Target: target, Strategy: StrategySymlink}, false},
+		{"missing", StateEntry{Source: source, Target: target + "2", Strategy: StrategySymlink}, false},
+		{"dir", StateEntry{Target: filepath.Join(tmpHomePath, ".homesick"), Strategy: StrategyMkdir}, true},
+		{"dir replaced", StateEntry{Target: target, Strategy: StrategyMkdir}, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.entry.Intact(); got != tc.want {
+				t.Errorf("unexpected result (got: %t, want %t)", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,29 +1,50 @@
 package main
 
 import (
-	"os/user"
+	"os"
 
+	"github.com/nemith/heartsick/homesick"
 	"github.com/spf13/cobra"
 )
 
+// gitBackendEnv set to `go` uses the pure Go git backend even when the git
+// binary is installed.
+const gitBackendEnv = "HEARTSICK_GIT"
+
 var (
 	heartsickVer = "0.0.0-dev"
-	homeDir      = mustHomeDir()
+	home         *homesick.Home
 	rootCmd      = &cobra.Command{
 		Use: "heartsick",
 	}
 )
 
 func main() {
+	// lets the pure Go backend use local repositories without the git binary
+	homesick.InstallFileTransport()
+
+	home = mustHome(homesick.Options{})
 	if err := rootCmd.Execute(); err != nil {
 		fatalf("failed to start command: %v", err)
 	}
 }
 
-func mustHomeDir() string {
-	user, err := user.Current()
+// mustHome returns the home directory everything is linked into with status
+// reported and commands run on the terminal.
+func mustHome(opts homesick.Options) *homesick.Home {
+	opts.Status = printStatus
+	opts.SecretKey = secretKey
+	opts.MergeTool = os.Getenv(mergeToolEnv)
+	opts.Stdin = os.Stdin
+	opts.Stdout = os.Stdout
+	opts.Stderr = os.Stderr
+	if opts.Git == nil && os.Getenv(gitBackendEnv) == "go" {
+		opts.Git = homesick.GoGit{}
+	}
+
+	h, err := homesick.New(opts)
 	if err != nil {
-		fatalf("couldn't find current user: %v", err)
+		fatalf("%v", err)
 	}
-	return user.HomeDir
+	return h
 }
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nemith/heartsick/homesick"
+)
+
+// fakeRepo is the state of a single repository in fakeGit.
+type fakeRepo struct {
+	remote    string
+	upstream  string
+	head      string
+	ahead     int
+	behind    int
+	dirty     []string
+	conflicts []string
+	commits   []string
+	refs      map[string]string
+
+	// incoming are the files changed by the next pull.
+	incoming []string
+	pullErr  error
+	pushErr  error
+	pushed   int
+	// needsAuth fails pushes that aren't interactive.
+	needsAuth   bool
+	interactive int
+	// fetched is the head of the last bundle fetched.
+	fetched string
+}
+
+// fakeGit is an in-memory homesick.GitBackend.
+type fakeGit struct {
+	mu    sync.Mutex
+	repos map[string]*fakeRepo
+
+	remotes map[string]*fakeRemote
+}
+
+// fakeRemote is what cloning a uri from fakeGit results in.
+type fakeRemote struct {
+	// files are written to the work tree.
+	files map[string]string
+	// refs are the branches and tags in addition to master.
+	refs map[string]string
+}
+
+func newFakeGit() *fakeGit {
+	return &fakeGit{
+		repos:   make(map[string]*fakeRepo),
+		remotes: make(map[string]*fakeRemote),
+	}
+}
+
+// useFakeGit will use a temporary home directory backed by a fake git for the
+// duration of the test.
+func useFakeGit(t *testing.T) *fakeGit {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "home")
+	if err != nil {
+		t.Fatalf("failed to create homedir: %v", err)
+	}
+
+	f := newFakeGit()
+	orig := home
+	home = mustHome(homesick.Options{HomeDir: dir, Git: f})
+	t.Cleanup(func() {
+		home = orig
+		os.RemoveAll(dir)
+	})
+	return f
+}
+
+var errFakeNotRepo = errors.New("not a git repository")
+
+func (f *fakeGit) repo(path string) (*fakeRepo, error) {
+	r, ok := f.repos[path]
+	if !ok {
+		return nil, errFakeNotRepo
+	}
+	return r, nil
+}
+
+func (f *fakeGit) Clone(uri, dest string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.MkdirAll(filepath.Join(dest, ".git"), 0755); err != nil {
+		return err
+	}
+	remote := f.remotes[uri]
+	if remote == nil {
+		remote = &fakeRemote{}
+	}
+	for name, content := range remote.files {
+		path := filepath.Join(dest, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		mode := os.FileMode(0644)
+		if strings.HasPrefix(name, "hooks/") {
+			mode = 0755
+		}
+		if err := ioutil.WriteFile(path, []byte(content), mode); err != nil {
+			return err
+		}
+	}
+	refs := map[string]string{"master": "1"}
+	for ref, id := range remote.refs {
+		refs[ref] = id
+	}
+	head := "1"
+	if id, ok := fakeBundleHead(uri); ok {
+		head = id
+	}
+	f.repos[dest] = &fakeRepo{
+		remote:   uri,
+		upstream: "origin/master",
+		head:     head,
+		refs:     refs,
+	}
+	return nil
+}
+
+func (f *fakeGit) Init(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.MkdirAll(filepath.Join(path, ".git"), 0755); err != nil {
+		return err
+	}
+	if _, ok := f.repos[path]; !ok {
+		f.repos[path] = &fakeRepo{}
+	}
+	return nil
+}
+
+func (f *fakeGit) IsRepo(path string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.repos[path]
+	return ok
+}
+
+func (f *fakeGit) Config(path, opt string) (string, error) {
+	return "", nil
+}
+
+func (f *fakeGit) RemoteURL(path string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return "", err
+	}
+	return r.remote, nil
+}
+
+func (f *fakeGit) RemoteExists(path, name string) bool {
+	url, _ := f.RemoteURL(path)
+	return name == "origin" && url != ""
+}
+
+func (f *fakeGit) RemoteAdd(path, name, url string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return err
+	}
+	r.remote = url
+	return nil
+}
+
+func (f *fakeGit) RemoteSetURL(path, name, url string) error {
+	return f.RemoteAdd(path, name, url)
+}
+
+func (f *fakeGit) FastForward(path, ref string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return err
+	}
+	if r.ahead > 0 {
+		return errors.New("Not possible to fast-forward, aborting.")
+	}
+	if r.fetched != "" {
+		r.head = r.fetched
+	}
+	return nil
+}
+
+func (f *fakeGit) CreateBundle(path, file string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(fakeBundlePrefix+r.head), 0644)
+}
+
+// fakeBundlePrefix starts the bundles written by fakeGit which only hold the
+// head they were created from.
+const fakeBundlePrefix = "fake bundle "
+
+// fakeBundleHead returns the head a fake bundle was created from.
+func fakeBundleHead(file string) (string, bool) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil || !strings.HasPrefix(string(data), fakeBundlePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(string(data), fakeBundlePrefix), true
+}
+
+func (f *fakeGit) FetchBundle(path, file string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return err
+	}
+	id, ok := fakeBundleHead(file)
+	if !ok {
+		return errors.New("not a bundle")
+	}
+	r.fetched = id
+	return nil
+}
+
+func (f *fakeGit) Fetch(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.repo(path)
+	return err
+}
+
+func (f *fakeGit) Pull(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return err
+	}
+	if r.pullErr != nil {
+		return r.pullErr
+	}
+	if len(r.incoming) > 0 {
+		r.head += "+"
+		r.behind = 0
+	}
+	return nil
+}
+
+func (f *fakeGit) Push(path string, w io.Writer, interactive bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return err
+	}
+	if r.pushErr != nil {
+		return r.pushErr
+	}
+	if r.needsAuth && !interactive {
+		return homesick.ErrNeedsCredentials
+	}
+	if interactive {
+		r.interactive++
+	}
+	r.ahead = 0
+	r.pushed++
+	return nil
+}
+
+func (f *fakeGit) Upstream(path string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return "", err
+	}
+	return r.upstream, nil
+}
+
+func (f *fakeGit) AheadBehind(path string) (int, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return r.ahead, r.behind, nil
+}
+
+func (f *fakeGit) CommitAll(path, msg string, edit bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return err
+	}
+	if len(r.dirty) == 0 {
+		return errors.New("nothing to commit")
+	}
+	r.commits = append(r.commits, msg)
+	r.dirty = nil
+	r.ahead++
+	return nil
+}
+
+func (f *fakeGit) AddUntracked(path, dir string) ([]string, error) {
+	return nil, nil
+}
+
+func (f *fakeGit) Head(path string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return "", err
+	}
+	return r.head, nil
+}
+
+func (f *fakeGit) ResolveRef(path, ref string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return "", err
+	}
+	id, ok := r.refs[ref]
+	if !ok {
+		return "", errors.New("unknown ref '" + ref + "'")
+	}
+	return id, nil
+}
+
+func (f *fakeGit) Checkout(path, ref string) error {
+	id, err := f.ResolveRef(path, ref)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.repos[path].head = id
+	return nil
+}
+
+func (f *fakeGit) ChangedFiles(path, from, to string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.incoming, nil
+}
+
+func (f *fakeGit) StatusFiles(path string, untracked bool) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.dirty, nil
+}
+
+func (f *fakeGit) ConflictedFiles(path string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.repo(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.conflicts, nil
+}
+
+func (f *fakeGit) FileVersions(path, file string, max int) ([][]byte, error) {
+	return nil, nil
+}
+
+func (f *fakeGit) Diff(path string, w io.Writer) error   { return nil }
+func (f *fakeGit) Status(path string, w io.Writer) error { return nil }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/nemith/heartsick/homesick"
+)
+
+// pushState is where a castle stands compared to its upstream.
+type pushState int
+
+const (
+	pushUpToDate pushState = iota
+	pushAhead
+	pushBehind
+	pushDiverged
+	pushNoUpstream
+)
+
+func (s pushState) String() string {
+	switch s {
+	case pushUpToDate:
+		return "up to date"
+	case pushAhead:
+		return "ahead"
+	case pushBehind:
+		return "behind"
+	case pushDiverged:
+		return "diverged"
+	case pushNoUpstream:
+		return "no upstream"
+	}
+	return "unknown"
+}
+
+// pushPreflight is the result of checking a castle before pushing.
+type pushPreflight struct {
+	castle   *homesick.Castle
+	state    pushState
+	upstream string
+	ahead    int
+	behind   int
+}
+
+// needsPush returns true if the castle has commits that can be pushed.
+func (p *pushPreflight) needsPush() bool {
+	return p.state == pushAhead
+}
+
+func (p *pushPreflight) String() string {
+	switch p.state {
+	case pushAhead:
+		return fmt.Sprintf("%d commit(s) ahead of %s", p.ahead, p.upstream)
+	case pushBehind:
+		return fmt.Sprintf("%d commit(s) behind %s, pull first", p.behind, p.upstream)
+	case pushDiverged:
+		return fmt.Sprintf("diverged from %s (%d ahead, %d behind), pull first", p.upstream, p.ahead, p.behind)
+	case pushNoUpstream:
+		return "no upstream branch configured"
+	}
+	return fmt.Sprintf("up to date with %s", p.upstream)
+}
+
+// preflightCastle works out if the castle has anything to push.  The remote is
+// fetched first if requested so being behind is noticed.
+func preflightCastle(c *homesick.Castle, fetch bool) (*pushPreflight, error) {
+	p := &pushPreflight{castle: c}
+
+	upstream, err := home.Git().Upstream(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	if upstream == "" {
+		p.state = pushNoUpstream
+		return p, nil
+	}
+	p.upstream = upstream
+
+	if fetch {
+		if err := home.Git().Fetch(c.Path); err != nil {
+			return nil, fmt.Errorf("failed to fetch: %v", err)
+		}
+	}
+
+	p.ahead, p.behind, err = home.Git().AheadBehind(c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.ahead > 0 && p.behind > 0:
+		p.state = pushDiverged
+	case p.ahead > 0:
+		p.state = pushAhead
+	case p.behind > 0:
+		p.state = pushBehind
+	default:
+		p.state = pushUpToDate
+	}
+	return p, nil
+}
+
+// pushResult is the outcome of pushing a single castle.
+type pushResult struct {
+	preflight *pushPreflight
+	err       error
+}
+
+// pushCastles will push every castle that is ahead of its upstream in
+// parallel.  Parallel pushes can't prompt for credentials so castles that
+// need them are pushed again one at a time on the terminal afterwards.
+// Results are returned in the same order as the preflights.
+func pushCastles(preflights []*pushPreflight) []pushResult {
+	results := make([]pushResult, len(preflights))
+
+	var wg sync.WaitGroup
+	for i, p := range preflights {
+		results[i].preflight = p
+		if !p.needsPush() {
+			continue
+		}
+
+		wg.Add(1)
+		go func(r *pushResult) {
+			defer wg.Done()
+			r.err = home.Git().Push(r.preflight.castle.Path, nil, false)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	for i := range results {
+		r := &results[i]
+		if !errors.Is(r.err, homesick.ErrNeedsCredentials) {
+			continue
+		}
+		statusf(colorBrCyan, "git push", "castle '%s' needs credentials", r.preflight.castle.Name)
+		r.err = home.Git().Push(r.preflight.castle.Path, os.Stdout, true)
+	}
+
+	return results
+}
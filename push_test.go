@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nemith/heartsick/homesick"
+)
+
+func TestPreflightCastle(t *testing.T) {
+	fake := useFakeGit(t)
+
+	tt := []struct {
+		name  string
+		repo  *fakeRepo
+		want  pushState
+		needs bool
+	}{
+		{"uptodate", &fakeRepo{upstream: "origin/master"}, pushUpToDate, false},
+		{"ahead", &fakeRepo{upstream: "origin/master", ahead: 2}, pushAhead, true},
+		{"behind", &fakeRepo{upstream: "origin/master", behind: 1}, pushBehind, false},
+		{"diverged", &fakeRepo{upstream: "origin/master", ahead: 1, behind: 1}, pushDiverged, false},
+		{"noupstream", &fakeRepo{ahead: 1}, pushNoUpstream, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			fake.repos["/"+tc.name] = tc.repo
+
+			p, err := preflightCastle(&homesick.Castle{Name: tc.name, Path: "/" + tc.name}, true)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p.state != tc.want {
+				t.Errorf("wrong state (got: %s, want: %s)", p.state, tc.want)
+			}
+			if p.needsPush() != tc.needs {
+				t.Errorf("wrong needsPush (got: %t, want: %t)", p.needsPush(), tc.needs)
+			}
+		})
+	}
+}
+
+func TestPushCastles(t *testing.T) {
+	fake := useFakeGit(t)
+	fake.repos["/a"] = &fakeRepo{upstream: "origin/master", ahead: 1}
+	fake.repos["/b"] = &fakeRepo{upstream: "origin/master"}
+	fake.repos["/c"] = &fakeRepo{upstream: "origin/master", ahead: 3, pushErr: errors.New("rejected")}
+	fake.repos["/d"] = &fakeRepo{upstream: "origin/master", ahead: 1, needsAuth: true}
+
+	var preflights []*pushPreflight
+	for _, name := range []string{"a", "b", "c", "d"} {
+		p, err := preflightCastle(&homesick.Castle{Name: name, Path: "/" + name}, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		preflights = append(preflights, p)
+	}
+
+	results := pushCastles(preflights)
+	if results[0].err != nil || fake.repos["/a"].pushed != 1 {
+		t.Errorf("castle a should have been pushed: %v", results[0].err)
+	}
+	if fake.repos["/b"].pushed != 0 {
+		t.Error("castle b shouldn't have been pushed")
+	}
+	if results[2].err == nil {
+		t.Error("castle c should have been rejected")
+	}
+	if results[3].err != nil || fake.repos["/d"].interactive != 1 {
+		t.Errorf("castle d should have been pushed interactively: %v", results[3].err)
+	}
+	if fake.repos["/a"].interactive != 0 {
+		t.Error("castle a shouldn't have been pushed interactively")
+	}
+}
+
+func TestCmdPushSingle(t *testing.T) {
+	fake := useFakeGit(t)
+
+	c, err := home.Clone("https://example.com/private.git", "private")
+	if err != nil {
+		t.Fatalf("failed to clone castle: %v", err)
+	}
+	// without an upstream the preflight would refuse to push
+	repo := fake.repos[c.Path]
+	repo.upstream = ""
+	repo.ahead = 1
+
+	cmdPush(nil, []string{"private"})
+	if repo.pushed != 1 || repo.interactive != 1 {
+		t.Errorf("castle should have been pushed interactively (pushed: %d, interactive: %d)", repo.pushed, repo.interactive)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/term"
+)
+
+const (
+	secretKeyEnv     = "HEARTSICK_KEY_FILE"
+	secretPassEnv    = "HEARTSICK_PASSPHRASE"
+	secretKeyDefault = "secret.key"
+)
+
+// flagKeyFile overrides the key file used to encrypt and decrypt secrets.
+var flagKeyFile string
+
+// secretMaterial is the cached passphrase or key file content so it's only
+// asked for once per run.
+var secretMaterial []byte
+
+// secretKeyFile returns the key file to use if one exists.  The --key-file
+// flag wins over $HEARTSICK_KEY_FILE which wins over ~/.homesick/secret.key.
+func secretKeyFile() string {
+	if flagKeyFile != "" {
+		return flagKeyFile
+	}
+	if path := os.Getenv(secretKeyEnv); path != "" {
+		return path
+	}
+	path := filepath.Join(home.DataDir(), secretKeyDefault)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	return ""
+}
+
+// secretKey returns the material used to derive encryption keys.  This is
+// either the contents of a key file or a passphrase from the environment or
+// asked for on the terminal.
+func secretKey() ([]byte, error) {
+	return loadSecretKey(false)
+}
+
+// confirmedSecretKey is secretKey for encrypting new secrets: a passphrase
+// asked for on the terminal has to be entered twice so a typo can't encrypt
+// a secret with a passphrase nobody knows.
+func confirmedSecretKey() ([]byte, error) {
+	return loadSecretKey(true)
+}
+
+func loadSecretKey(confirm bool) ([]byte, error) {
+	if secretMaterial != nil {
+		return secretMaterial, nil
+	}
+
+	if path := secretKeyFile(); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %v", err)
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			return nil, fmt.Errorf("key file '%s' is empty", path)
+		}
+		secretMaterial = data
+		return secretMaterial, nil
+	}
+
+	if pass := os.Getenv(secretPassEnv); pass != "" {
+		secretMaterial = []byte(pass)
+		return secretMaterial, nil
+	}
+
+	pass, err := readPassphrase("Passphrase for secrets: ")
+	if err != nil {
+		return nil, err
+	}
+	if pass == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	if confirm {
+		again, err := readPassphrase("Repeat passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if again != pass {
+			return nil, errors.New("passphrases don't match")
+		}
+	}
+	secretMaterial = []byte(pass)
+	return secretMaterial, nil
+}
+
+// readPassphrase will prompt for a passphrase on the terminal with echo turned
+// off.  When stdin isn't a terminal a line is read from it instead.
+func readPassphrase(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := readLine()
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %v", err)
+		}
+		return line, nil
+	}
+
+	fmt.Print(prompt)
+	pass, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	return string(pass), nil
+}
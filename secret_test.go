@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// withStdin replaces stdin with a file holding input for the rest of the
+// test.
+func withStdin(t *testing.T, input string) {
+	t.Helper()
+	f, err := ioutil.TempFile("", "stdin")
+	if err != nil {
+		t.Fatalf("failed to create stdin: %v", err)
+	}
+	t.Cleanup(func() { f.Close(); os.Remove(f.Name()) })
+	if _, err := f.WriteString(input); err != nil {
+		t.Fatalf("failed to write stdin: %v", err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatalf("failed to rewind stdin: %v", err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() { os.Stdin = stdin })
+}
+
+func TestConfirmedSecretKey(t *testing.T) {
+	useFakeGit(t)
+	t.Setenv(secretKeyEnv, "")
+	t.Setenv(secretPassEnv, "")
+	t.Cleanup(func() { secretMaterial = nil })
+
+	withStdin(t, "hunter2\nhunter3\n")
+	if _, err := confirmedSecretKey(); err == nil {
+		t.Error("expected mismatched passphrases to fail")
+	}
+
+	// the passphrase is read without eating the answers to later prompts
+	withStdin(t, "hunter2\nhunter2\nn\r\ny")
+	key, err := confirmedSecretKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(key) != "hunter2" {
+		t.Errorf("wrong passphrase %q", key)
+	}
+	for _, want := range []string{"n", "y"} {
+		if got, err := readLine(); err != nil || got != want {
+			t.Errorf("wrong line after passphrase (got: %q, %v, want: %q)", got, err, want)
+		}
+	}
+}
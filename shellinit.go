@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+// shellInitData is what the shell init scripts are rendered with.
+type shellInitData struct {
+	Name  string
+	Check bool
+	Env   [][2]string
+}
+
+func cmdInit(cmd *cobra.Command, args []string) {
+	if err := writeShellInit(os.Stdout, args[0], flagInitCheck); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// writeShellInit renders the init script for a shell to w.
+func writeShellInit(w io.Writer, shell string, check bool) error {
+	script, ok := shellInitScripts[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell '%s' (expected bash, zsh or fish)", shell)
+	}
+
+	funcs := template.FuncMap{"quote": shellQuote}
+	if shell == "fish" {
+		funcs["quote"] = fishQuote
+	}
+
+	t := template.Must(template.New(shell).Funcs(funcs).Parse(script))
+	err := t.Execute(w, shellInitData{
+		Name:  rootCmd.Name(),
+		Check: check,
+		Env: [][2]string{
+			{"HEARTSICK_HOME", home.Dir()},
+			{"HEARTSICK_CASTLE_ROOT", home.CastleRoot()},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write init script: %v", err)
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// fishQuote quotes s for fish which allows escaping inside single quotes.
+func fishQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return "'" + strings.Replace(s, "'", `\'`, -1) + "'"
+}
+
+// posixShellInit is shared by bash and zsh.  The function replaces the
+// command so `cd` (and its `shell` alias) changes the directory of the current
+// shell instead of starting a new one.
+const posixShellInit = `{{range .Env}}export {{index . 0}}={{quote (index . 1)}}
+{{end}}
+{{.Name}}() {
+    case "$1" in
+    cd|shell)
+        shift
+        local dir
+        if dir="$(command {{.Name}} show_path "$@")"; then
+            cd "$dir"
+        else
+            printf '%s\n' "$dir" >&2
+            return 1
+        fi
+        ;;
+    *)
+        command {{.Name}} "$@"
+        ;;
+    esac
+}
+`
+
+var shellInitScripts = map[string]string{
+	"bash": `# {{.Name}} shell integration for bash
+# add 'eval "$({{.Name}} init bash)"' to ~/.bashrc
+` + posixShellInit + `{{if .Check}}
+if shopt -q login_shell; then
+    command {{.Name}} check --quiet
+fi
+{{end}}`,
+
+	"zsh": `# {{.Name}} shell integration for zsh
+# add 'eval "$({{.Name}} init zsh)"' to ~/.zshrc
+` + posixShellInit + `{{if .Check}}
+if [[ -o login ]]; then
+    command {{.Name}} check --quiet
+fi
+{{end}}`,
+
+	"fish": `# {{.Name}} shell integration for fish
+# add '{{.Name}} init fish | source' to ~/.config/fish/config.fish
+{{range .Env}}set -gx {{index . 0}} {{quote (index . 1)}}
+{{end}}
+function {{.Name}}
+    if contains -- "$argv[1]" cd shell
+        set -e argv[1]
+        set -l dir (command {{.Name}} show_path $argv)
+        or begin
+            printf '%s\n' $dir >&2
+            return 1
+        end
+        cd $dir
+    else
+        command {{.Name}} $argv
+    end
+end
+{{if .Check}}
+if status is-login
+    command {{.Name}} check --quiet
+end
+{{end}}`,
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tt := []struct {
+		in, posix, fish string
+	}{
+		{"/home/me", `'/home/me'`, `'/home/me'`},
+		{"it's", `'it'\''s'`, `'it\'s'`},
+		{`a\b`, `'a\b'`, `'a\\b'`},
+	}
+
+	for _, tc := range tt {
+		if got := shellQuote(tc.in); got != tc.posix {
+			t.Errorf("shellQuote(%q) = %s, want %s", tc.in, got, tc.posix)
+		}
+		if got := fishQuote(tc.in); got != tc.fish {
+			t.Errorf("fishQuote(%q) = %s, want %s", tc.in, got, tc.fish)
+		}
+	}
+}
+
+func TestShellInitSyntax(t *testing.T) {
+	useFakeGit(t)
+
+	for _, shell := range []string{"bash", "zsh"} {
+		path, err := exec.LookPath(shell)
+		if err != nil {
+			continue
+		}
+		shell := shell
+		t.Run(shell, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeShellInit(&buf, shell, true); err != nil {
+				t.Fatalf("failed to write init script: %v", err)
+			}
+			script := buf.String()
+			cmd := exec.Command(path, "-n", "-c", script)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("init script isn't valid %s: %v\n%s\n%s", shell, err, out, script)
+			}
+		})
+	}
+}
+
+func TestShellInitUnsupported(t *testing.T) {
+	useFakeGit(t)
+
+	var buf bytes.Buffer
+	if err := writeShellInit(&buf, "tcsh", false); err == nil {
+		t.Error("expected an error for an unsupported shell")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nemith/heartsick/homesick"
+	"github.com/spf13/cobra"
+)
+
+// syncReport is the outcome of syncing a single castle.
+type syncReport struct {
+	castle    *homesick.Castle
+	pulled    int
+	linked    int
+	committed int
+	pushed    bool
+	err       error
+}
+
+func (r *syncReport) String() string {
+	if r.err != nil {
+		return r.err.Error()
+	}
+
+	var parts []string
+	parts = append(parts, fmt.Sprintf("pulled %d file(s)", r.pulled))
+	parts = append(parts, fmt.Sprintf("linked %d file(s)", r.linked))
+	if r.committed > 0 {
+		parts = append(parts, fmt.Sprintf("committed %d file(s)", r.committed))
+	} else {
+		parts = append(parts, "nothing to commit")
+	}
+	if r.pushed {
+		parts = append(parts, "pushed")
+	} else {
+		parts = append(parts, "nothing to push")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// syncCastle will pull, link, commit and push a single castle stopping at the
+// first failure.  Merge conflicts stop the sync before anything is linked.
+func syncCastle(c *homesick.Castle, state *homesick.State) *syncReport {
+	r := &syncReport{castle: c}
+
+	conflicts, err := home.Git().ConflictedFiles(c.Path)
+	if err != nil {
+		r.err = fmt.Errorf("failed to check for conflicts: %v", err)
+		return r
+	}
+	if len(conflicts) > 0 {
+		r.err = fmt.Errorf("unresolved merge conflicts in %s", strings.Join(conflicts, ", "))
+		return r
+	}
+
+	pulled, err := c.Pull()
+	if err != nil {
+		if conflicts, _ := home.Git().ConflictedFiles(c.Path); len(conflicts) > 0 {
+			err = fmt.Errorf("pull left merge conflicts in %s", strings.Join(conflicts, ", "))
+		}
+		r.err = err
+		return r
+	}
+	r.pulled = len(pulled)
+
+	linked, err := c.Link(state, conflictPrompt)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.linked = len(linked)
+
+	committed, err := c.Commit("", false, false)
+	if err != nil {
+		r.err = fmt.Errorf("failed to commit: %v", err)
+		return r
+	}
+	r.committed = len(committed)
+
+	// the castle was just pulled so there is no need to fetch again
+	p, err := preflightCastle(c, false)
+	if err != nil {
+		r.err = fmt.Errorf("failed to check for unpushed commits: %v", err)
+		return r
+	}
+	if !p.needsPush() {
+		return r
+	}
+
+	statusf(colorBrGreen, "git push", "castle '%s'", c.Name)
+	if err := c.Push(); err != nil {
+		r.err = fmt.Errorf("failed to push: %v", err)
+		return r
+	}
+	r.pushed = true
+
+	return r
+}
+
+func cmdSync(cmd *cobra.Command, args []string) {
+	var castles []*homesick.Castle
+	if flagAll {
+		castles = mustAllCastles()
+	} else {
+		castles = castlesFromArgs(args)
+	}
+
+	state, err := home.LoadState()
+	if err != nil {
+		fatalf("failed to load state: %v", err)
+	}
+
+	reports := make([]*syncReport, 0, len(castles))
+	for _, c := range castles {
+		statusf(colorBrCyan, "sync", "castle '%s'", c.Name)
+		reports = append(reports, syncCastle(c, state))
+	}
+
+	fmt.Println()
+	var fail bool
+	for _, r := range reports {
+		if r.err != nil {
+			status(colorBrRed, r.castle.Name, r.String())
+			fail = true
+			continue
+		}
+		status(colorBrGreen, r.castle.Name, r.String())
+	}
+	if fail {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncCastle(t *testing.T) {
+	fake := useFakeGit(t)
+
+	c, err := home.Clone("https://example.com/private.git", "private")
+	if err != nil {
+		t.Fatalf("failed to clone castle: %v", err)
+	}
+	for _, name := range []string{".file1", ".file2"} {
+		if err := os.MkdirAll(c.HomePath(), 0755); err != nil {
+			t.Fatalf("failed to create castle home: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(c.HomePath(), name), nil, 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	repo := fake.repos[c.Path]
+	repo.behind = 1
+	repo.incoming = []string{"home/.file1"}
+	repo.dirty = []string{"home/.file2"}
+
+	state, _ := home.LoadState()
+	r := syncCastle(c, state)
+	if r.err != nil {
+		t.Fatalf("unexpected error: %v", r.err)
+	}
+
+	if r.pulled != 1 || r.linked != 2 || r.committed != 1 || !r.pushed {
+		t.Errorf("wrong report: %s", r)
+	}
+	if len(repo.commits) != 1 || repo.commits[0] != "Update ~/.file2" {
+		t.Errorf("wrong commits: %v", repo.commits)
+	}
+	if _, err := os.Readlink(filepath.Join(home.Dir(), ".file1")); err != nil {
+		t.Errorf("castle wasn't linked: %v", err)
+	}
+
+	// conflicts stop the sync before anything else happens
+	repo.conflicts = []string{"home/.file1"}
+	repo.dirty = []string{"home/.file1"}
+	r = syncCastle(c, state)
+	if r.err == nil {
+		t.Fatal("expected conflicts to stop the sync")
+	}
+	if len(repo.commits) != 1 {
+		t.Errorf("nothing should be committed with conflicts: %v", repo.commits)
+	}
+}
@@ -2,7 +2,12 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
+
+	"github.com/nemith/heartsick/homesick"
+	"golang.org/x/term"
 )
 
 type color string
@@ -15,7 +20,15 @@ const (
 	colorBrCyan  color = "\x1b[96m"
 )
 
+// colorOutput is set when stdout is a terminal so escape codes don't end up
+// in pipes and files.
+var colorOutput = term.IsTerminal(int(os.Stdout.Fd()))
+
 func status(color color, s, msg string) {
+	if !colorOutput {
+		fmt.Printf("%15s  %s\n", s, msg)
+		return
+	}
 	fmt.Printf("%s%15s%s  %s\n", color, s, colorNone, msg)
 }
 
@@ -23,6 +36,19 @@ func statusf(color color, s, msg string, v ...interface{}) {
 	status(color, s, fmt.Sprintf(msg, v...))
 }
 
+// printStatus writes status reported by the homesick package to the
+// terminal.
+func printStatus(level homesick.StatusLevel, action, msg string) {
+	switch level {
+	case homesick.StatusChange:
+		status(colorBrGreen, action, msg)
+	case homesick.StatusProblem:
+		status(colorBrRed, action, msg)
+	default:
+		status(colorBrBlue, action, msg)
+	}
+}
+
 func errorf(f string, v ...interface{}) {
 	statusf(colorBrRed, "error", f, v...)
 }
@@ -31,3 +57,28 @@ func fatalf(f string, v ...interface{}) {
 	errorf(f, v...)
 	os.Exit(1)
 }
+
+// readLine reads a line from stdin without its line ending.  Stdin is read a
+// byte at a time instead of buffered so anything after the line is left for
+// the next prompt or the commands heartsick runs.
+func readLine() (string, error) {
+	var (
+		line []byte
+		b    = make([]byte, 1)
+	)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return strings.TrimSuffix(string(line), "\r"), nil
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			return string(line), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/nemith/heartsick/homesick"
+	"github.com/spf13/cobra"
+)
+
+// castleWatcher tracks the changes in a castle so they are only committed
+// once they have stopped changing.
+type castleWatcher struct {
+	castle   *homesick.Castle
+	debounce time.Duration
+	status   func(path string) ([]string, error)
+
+	pending   bool
+	changedAt time.Time
+	timer     *time.Timer
+}
+
+func newCastleWatcher(c *homesick.Castle, debounce time.Duration) *castleWatcher {
+	return &castleWatcher{
+		castle:   c,
+		debounce: debounce,
+		status: func(path string) ([]string, error) {
+			return home.Git().StatusFiles(path, false)
+		},
+	}
+}
+
+// changed records a change to the castle's work tree.
+func (w *castleWatcher) changed(now time.Time) {
+	w.pending = true
+	w.changedAt = now
+}
+
+// settle will send the watcher on settled once the castle has been quiet for
+// the debounce window.  Every castle has its own timer so changes in one
+// castle don't hold back the commits of another.
+func (w *castleWatcher) settle(settled chan<- *castleWatcher) {
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.debounce, func() { settled <- w })
+		return
+	}
+	w.timer.Reset(w.debounce)
+}
+
+// check returns the changed files once the castle hasn't changed for the
+// debounce window.  Nil is returned while the castle is clean or still
+// changing.
+func (w *castleWatcher) check(now time.Time) ([]string, error) {
+	if !w.pending || now.Sub(w.changedAt) < w.debounce {
+		return nil, nil
+	}
+	w.pending = false
+
+	files, err := w.status(w.castle.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return files, nil
+}
+
+// owns returns true if path is in the castle's work tree.  Changes to the git
+// directory are left out so commits don't wake the watcher.
+func (w *castleWatcher) owns(path string) bool {
+	rel, err := filepath.Rel(w.castle.Path, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	return rel != ".git" && !strings.HasPrefix(rel, ".git"+string(filepath.Separator))
+}
+
+// watchTree will add dir and every directory below it to the watcher skipping
+// git directories.
+func watchTree(fw *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// directories removed while walking are fine
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if fi.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		return fw.Add(path)
+	})
+}
+
+// commit will commit the changes in the castle and push them if requested.
+func (w *castleWatcher) commit(files []string) error {
+	statusf(colorBrGreen, "git commit all", "%d file(s) in castle '%s'", len(files), w.castle.Name)
+	if err := home.Git().CommitAll(w.castle.Path, w.castle.CommitMessage(files), false); err != nil {
+		return err
+	}
+
+	if flagWatchPush {
+		statusf(colorBrGreen, "git push", "castle '%s'", w.castle.Name)
+		if err := w.castle.Push(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdWatch(cmd *cobra.Command, args []string) {
+	if flagWatchInstall {
+		installWatchService(args)
+		return
+	}
+
+	var castles []*homesick.Castle
+	if flagAll {
+		castles = mustAllCastles()
+	} else {
+		castles = castlesFromArgs(args)
+	}
+
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		fatalf("failed to create watcher: %v", err)
+	}
+	defer fw.Close()
+
+	settled := make(chan *castleWatcher)
+	watchers := make([]*castleWatcher, 0, len(castles))
+	for _, c := range castles {
+		statusf(colorBrCyan, "watch", "castle '%s'", c.Name)
+		if err := watchTree(fw, c.Path); err != nil {
+			fatalf("failed to watch castle '%s': %v", c.Name, err)
+		}
+		w := newCastleWatcher(c, flagWatchDebounce)
+		// changes made while heartsick wasn't running are committed too
+		w.changed(time.Now())
+		w.settle(settled)
+		watchers = append(watchers, w)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	for {
+		select {
+		case <-sigs:
+			return
+		case err := <-fw.Errors:
+			errorf("watcher failed: %v", err)
+		case ev := <-fw.Events:
+			for _, w := range watchers {
+				if !w.owns(ev.Name) {
+					continue
+				}
+				if ev.Op&fsnotify.Create != 0 {
+					if fi, err := os.Lstat(ev.Name); err == nil && fi.IsDir() {
+						if err := watchTree(fw, ev.Name); err != nil {
+							errorf("failed to watch '%s': %v", ev.Name, err)
+						}
+					}
+				}
+				w.changed(time.Now())
+				w.settle(settled)
+			}
+		case w := <-settled:
+			// a timer that fired just before another change is ignored by
+			// check as the castle hasn't settled yet
+			files, err := w.check(time.Now())
+			if err != nil {
+				errorf("failed to check castle '%s': %v", w.castle.Name, err)
+				continue
+			}
+			if files == nil {
+				continue
+			}
+			if err := w.commit(files); err != nil {
+				errorf("failed to commit castle '%s': %v", w.castle.Name, err)
+			}
+		}
+	}
+}
+
+// systemdQuote will quote an argument of a systemd Exec line so spaces, quotes
+// and `%` specifiers and `$` variables in it are passed through as is.
+func systemdQuote(arg string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "%", "%%", "$", "$$")
+	return `"` + r.Replace(arg) + `"`
+}
+
+var systemdUnitTmpl = template.Must(template.New("systemd").Funcs(template.FuncMap{
+	"systemdQuote": systemdQuote,
+}).Parse(`[Unit]
+Description=heartsick castle watcher
+
+[Service]
+ExecStart={{range $i, $a := .Args}}{{if $i}} {{end}}{{systemdQuote $a}}{{end}}
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`))
+
+var launchdPlistTmpl = template.Must(template.New("launchd").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{html .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>{{range .Args}}
+		<string>{{html .}}</string>{{end}}
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+</dict>
+</plist>
+`))
+
+// installWatchService will write a user service running the watcher with the
+// same options.  Linux uses a systemd user unit and macOS a launchd agent.
+func installWatchService(args []string) {
+	exe, err := os.Executable()
+	if err != nil {
+		fatalf("failed to find heartsick executable: %v", err)
+	}
+
+	svcArgs := []string{exe, "watch",
+		"--debounce", flagWatchDebounce.String(),
+	}
+	if flagWatchPush {
+		svcArgs = append(svcArgs, "--push")
+	}
+	if flagAll {
+		svcArgs = append(svcArgs, "--all")
+	} else {
+		// groups are kept so changes to the config are picked up
+		castlesFromArgs(args)
+		if len(args) == 0 {
+			args = []string{homesick.DefaultCastle}
+		}
+		svcArgs = append(svcArgs, args...)
+	}
+
+	const label = "com.github.nemith.heartsick.watch"
+	data := struct {
+		Label string
+		Args  []string
+	}{label, svcArgs}
+
+	var (
+		path   string
+		tmpl   *template.Template
+		enable string
+	)
+	switch runtime.GOOS {
+	case "darwin":
+		path = filepath.Join(home.Dir(), "Library/LaunchAgents", label+".plist")
+		tmpl = launchdPlistTmpl
+		enable = "launchctl load -w " + path
+	case "linux":
+		path = filepath.Join(home.Dir(), ".config/systemd/user/heartsick-watch.service")
+		tmpl = systemdUnitTmpl
+		enable = "systemctl --user enable --now heartsick-watch.service"
+	default:
+		fatalf("installing a service isn't supported on %s", runtime.GOOS)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		fatalf("failed to generate service: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fatalf("failed to create service directory: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		fatalf("failed to write service: %v", err)
+	}
+
+	status(colorBrGreen, "create", path)
+	statusf(colorBrCyan, "enable", "run `%s` to start the watcher", enable)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nemith/heartsick/homesick"
+)
+
+func TestCastleWatcherDebounce(t *testing.T) {
+	var (
+		files    []string
+		statuses int
+	)
+	w := &castleWatcher{
+		castle:   &homesick.Castle{Name: "dotfiles", Path: "/nonexistent"},
+		debounce: 10 * time.Second,
+		status: func(path string) ([]string, error) {
+			statuses++
+			return files, nil
+		},
+	}
+
+	start := time.Now()
+	steps := []struct {
+		after   time.Duration
+		changed bool
+		files   []string
+		commit  bool
+	}{
+		{0, false, nil, false},
+		{1 * time.Second, true, []string{"home/.vimrc"}, false},
+		{5 * time.Second, false, []string{"home/.vimrc"}, false},
+		{8 * time.Second, true, []string{"home/.vimrc", "home/.tmux.conf"}, false},
+		{17 * time.Second, false, []string{"home/.vimrc", "home/.tmux.conf"}, false},
+		{18 * time.Second, false, []string{"home/.vimrc", "home/.tmux.conf"}, true},
+		{29 * time.Second, false, nil, false},
+		// changes to ignored files leave the castle clean
+		{30 * time.Second, true, nil, false},
+		{41 * time.Second, false, nil, false},
+	}
+
+	for _, step := range steps {
+		files = step.files
+		now := start.Add(step.after)
+		if step.changed {
+			w.changed(now)
+		}
+		got, err := w.check(now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if (got != nil) != step.commit {
+			t.Errorf("at %s: got commit %t, want %t", step.after, got != nil, step.commit)
+		}
+	}
+	// git is only asked once the castle has settled
+	if statuses != 2 {
+		t.Errorf("git status was run %d times, want 2", statuses)
+	}
+}
+
+func TestCastleWatcherSettle(t *testing.T) {
+	quiet := &castleWatcher{castle: &homesick.Castle{Name: "quiet"}, debounce: 20 * time.Millisecond}
+	busy := &castleWatcher{castle: &homesick.Castle{Name: "busy"}, debounce: 20 * time.Millisecond}
+
+	settled := make(chan *castleWatcher)
+	quiet.settle(settled)
+	busy.settle(settled)
+	defer busy.timer.Stop()
+
+	// a castle that keeps changing doesn't hold back the others
+	tick := time.NewTicker(5 * time.Millisecond)
+	defer tick.Stop()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case <-tick.C:
+			busy.settle(settled)
+		case w := <-settled:
+			if w != quiet {
+				t.Fatalf("castle '%s' settled while changing", w.castle.Name)
+			}
+			return
+		case <-timeout:
+			t.Fatal("quiet castle never settled")
+		}
+	}
+}
+
+func TestCastleWatcherOwns(t *testing.T) {
+	w := &castleWatcher{castle: &homesick.Castle{Name: "dotfiles", Path: "/repos/dotfiles"}}
+
+	tt := []struct {
+		path string
+		want bool
+	}{
+		{"/repos/dotfiles/home/.vimrc", true},
+		{"/repos/dotfiles/.gitignore", true},
+		{"/repos/dotfiles/.git/index", false},
+		{"/repos/dotfiles/.git", false},
+		{"/repos/dotfiles-work/home/.vimrc", false},
+	}
+	for _, tc := range tt {
+		if got := w.owns(tc.path); got != tc.want {
+			t.Errorf("owns(%s) = %t, want %t", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestSystemdUnit(t *testing.T) {
+	var b strings.Builder
+	err := systemdUnitTmpl.Execute(&b, struct{ Args []string }{
+		[]string{"/opt/my apps/heartsick", "watch", "100%", `"quoted"`},
+	})
+	if err != nil {
+		t.Fatalf("failed to render unit: %v", err)
+	}
+	want := `ExecStart="/opt/my apps/heartsick" "watch" "100%%" "\"quoted\""` + "\n"
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("unit is missing %q:\n%s", want, b.String())
+	}
+}